@@ -1,11 +1,15 @@
 module github.com/cargo-lambda/cargo-lambda-cdk/cargolambdacdk
 
-go 1.18
+go 1.21
 
 require (
-	github.com/aws/jsii-runtime-go v1.105.0
 	github.com/aws/aws-cdk-go/awscdk/v2 v2.63.0
-	github.com/aws/constructs-go/constructs/v10 v10.0.5
+	github.com/aws/constructs-go/constructs/v10 v10.1.235
+	github.com/aws/jsii-runtime-go v1.105.0
+)
+
+require (
+	github.com/Masterminds/semver/v3 v3.3.0 // indirect
 	github.com/cdklabs/awscdk-asset-awscli-go/awscliv1/v2 v2.2.212 // indirect
 	github.com/cdklabs/awscdk-asset-kubectl-go/kubectlv20/v2 v2.1.3 // indirect
 	github.com/cdklabs/awscdk-asset-node-proxy-agent-go/nodeproxyagentv5/v2 v2.0.166 // indirect
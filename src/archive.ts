@@ -0,0 +1,273 @@
+import { createHash } from 'node:crypto';
+import { copyFileSync, existsSync, lstatSync, mkdirSync, readdirSync, readFileSync, realpathSync, renameSync, rmSync, symlinkSync, writeFileSync } from 'node:fs';
+import { dirname, join, posix, relative, resolve, sep } from 'node:path';
+import { gunzipSync } from 'node:zlib';
+import { tmpdir } from 'os';
+import { readZipEntries } from './artifact';
+
+/**
+ * The archive formats that the source code can be extracted from.
+ */
+const ARCHIVE_FORMATS: { [extension: string]: 'zip' | 'tar' | 'tar.gz' } = {
+  '.zip': 'zip',
+  '.tar': 'tar',
+  '.tar.gz': 'tar.gz',
+  '.tgz': 'tar.gz',
+  '.crate': 'tar.gz', // the output of `cargo package`
+};
+
+interface ArchiveEntry {
+  readonly path: string;
+  readonly type: 'file' | 'directory' | 'symlink' | 'hardlink';
+  readonly mode?: number;
+  readonly linkName?: string;
+  readonly content: () => Buffer;
+}
+
+/**
+ * Extract a source archive into a directory named after the archive's content, and return the
+ * root of the extracted sources.
+ *
+ * The archive is only extracted once, later synthesis reuse the directory. When the archive
+ * contains a single directory, like the `<name>-<version>` directory in `.crate` files,
+ * that directory is the root of the sources.
+ */
+export function extractSourceArchive(archivePath: string): string {
+  const path = resolve(archivePath);
+  if (!existsSync(path)) {
+    throw new Error(`the source archive \`${archivePath}\` doesn't exist`);
+  }
+
+  const extension = Object.keys(ARCHIVE_FORMATS).find(ext => path.endsWith(ext));
+  if (!extension) {
+    throw new Error(`the source archive \`${archivePath}\` is not supported, use a \`.tar.gz\`, \`.tgz\`, \`.tar\`, \`.zip\` or \`.crate\` file`);
+  }
+
+  const data = readFileSync(path);
+  const hash = createHash('sha256').update(data).digest('hex');
+  const extractDir = join(sourceCacheDirectory(), `cargo-lambda-cdk-source-${hash.slice(0, 16)}`);
+
+  if (!existsSync(extractDir)) {
+    // extract into a different directory first, so an interrupted extraction is never reused
+    const partialDir = `${extractDir}.partial-${process.pid}`;
+    rmSync(partialDir, { recursive: true, force: true });
+    try {
+      const format = ARCHIVE_FORMATS[extension];
+      const entries = format === 'zip' ? zipEntries(path) : tarEntries(format === 'tar.gz' ? gunzipSync(data) : data);
+      writeEntries(partialDir, entries, archivePath);
+      renameSync(partialDir, extractDir);
+    } catch (err) {
+      rmSync(partialDir, { recursive: true, force: true });
+      if (!existsSync(extractDir)) { // another process extracted the same archive
+        throw err;
+      }
+    }
+  }
+
+  const entries = readdirSync(extractDir, { withFileTypes: true });
+  if (!existsSync(join(extractDir, 'Cargo.toml')) && entries.length === 1 && entries[0].isDirectory()) {
+    return join(extractDir, entries[0].name);
+  }
+  return extractDir;
+}
+
+/**
+ * The directory where the archives are extracted, only the current user can write to it,
+ * so the extracted sources can't be replaced by other users of the machine.
+ */
+function sourceCacheDirectory(): string {
+  const uid = process.getuid?.();
+  const dir = join(tmpdir(), uid === undefined ? 'cargo-lambda-cdk-sources' : `cargo-lambda-cdk-sources-${uid}`);
+  mkdirSync(dir, { recursive: true, mode: 0o700 });
+
+  const stat = lstatSync(dir);
+  // the permissions of the group and the others are the last two octal digits of the mode
+  if (uid !== undefined && (!stat.isDirectory() || stat.uid !== uid || stat.mode % 0o100 !== 0)) {
+    throw new Error(`the directory \`${dir}\` to extract the source archives must be a directory that only the current user can access, remove it to create it again`);
+  }
+  return dir;
+}
+
+function writeEntries(root: string, entries: ArchiveEntry[], archivePath: string) {
+  mkdirSync(root, { recursive: true });
+
+  const destination = (entryPath: string) => {
+    const normalized = posix.normalize(entryPath.replace(/\\/g, '/')).replace(/\/+$/, '');
+    const dest = resolve(root, normalized);
+    const rel = relative(root, dest);
+    if (posix.isAbsolute(normalized) || rel === '..' || rel.startsWith('..' + sep)) {
+      throw new Error(`the source archive \`${archivePath}\` contains the path \`${entryPath}\` outside the archive root`);
+    }
+
+    // the links are only checked lexically, so a path through a link that the archive created
+    // can end outside the root, i.e. through a link to a directory that contains another link
+    let current = root;
+    for (const part of rel.split(sep)) {
+      current = join(current, part);
+      if (lstatSync(current, { throwIfNoEntry: false })?.isSymbolicLink()) {
+        throw new Error(`the source archive \`${archivePath}\` contains the path \`${entryPath}\` through the symbolic link \`${relative(root, current).split(sep).join(posix.sep)}\``);
+      }
+    }
+    return dest;
+  };
+
+  const links: string[] = [];
+  for (const entry of entries) {
+    const dest = destination(entry.path);
+    if (dest === root) {
+      continue;
+    }
+
+    switch (entry.type) {
+      case 'directory':
+        mkdirSync(dest, { recursive: true });
+        break;
+      case 'file':
+        mkdirSync(dirname(dest), { recursive: true });
+        writeFileSync(dest, entry.content(), { mode: entry.mode ? entry.mode % 0o1000 : 0o644 });
+        break;
+      case 'symlink': {
+        const target = resolve(dirname(dest), entry.linkName!);
+        destination(relative(root, target).split(sep).join(posix.sep));
+        mkdirSync(dirname(dest), { recursive: true });
+        symlinkSync(entry.linkName!, dest);
+        links.push(dest);
+        break;
+      }
+      case 'hardlink':
+        mkdirSync(dirname(dest), { recursive: true });
+        copyFileSync(destination(entry.linkName!), dest);
+        break;
+    }
+  }
+
+  // a link can still resolve outside the root through a link that was created after it. The native
+  // realpath follows the links like the OS does, the JS version removes the `..` components first.
+  const realRoot = realpathSync.native(root);
+  for (const link of links) {
+    const target = existsSync(link) ? realpathSync.native(link) : undefined; // links to missing files are harmless
+    const rel = target && relative(realRoot, target);
+    if (rel && (rel === '..' || rel.startsWith('..' + sep) || resolve(rel) === rel)) {
+      throw new Error(`the source archive \`${archivePath}\` contains the symbolic link \`${relative(root, link).split(sep).join(posix.sep)}\` to a path outside the archive root`);
+    }
+  }
+}
+
+// the file type of the symbolic links in a Unix mode, above the permissions and the setuid, setgid and sticky bits
+const SYMLINK_FILE_TYPE = 0o120000;
+
+function zipEntries(path: string): ArchiveEntry[] {
+  return readZipEntries(path).map(entry => {
+    const mode = entry.unixMode;
+    if (mode !== undefined && mode - (mode % 0o10000) === SYMLINK_FILE_TYPE) {
+      return { path: entry.name, type: 'symlink', linkName: entry.read().toString('utf-8'), content: entry.read };
+    }
+    return {
+      path: entry.name,
+      type: entry.name.endsWith('/') ? 'directory' : 'file',
+      mode: mode ? mode % 0o1000 : undefined,
+      content: entry.read,
+    };
+  });
+}
+
+const TAR_ENTRY_TYPES: { [type: string]: ArchiveEntry['type'] } = {
+  0: 'file',
+  7: 'file', // contiguous file
+  5: 'directory',
+  2: 'symlink',
+  1: 'hardlink',
+};
+
+/**
+ * Read the entries of a tar file, with the ustar, pax and GNU long name extensions.
+ */
+function tarEntries(data: Buffer): ArchiveEntry[] {
+  const entries: ArchiveEntry[] = [];
+  let longName: string | undefined;
+  let longLinkName: string | undefined;
+  let pax: { [key: string]: string } = {};
+
+  let offset = 0;
+  while (offset + 512 <= data.length) {
+    const header = data.subarray(offset, offset + 512);
+    if (header.every(byte => byte === 0)) {
+      break; // the end of the archive
+    }
+
+    const size = tarNumber(header.subarray(124, 136));
+    const content = data.subarray(offset + 512, offset + 512 + size);
+    offset += 512 + Math.ceil(size / 512) * 512;
+
+    const type = header[156] === 0 ? '0' : String.fromCharCode(header[156]);
+    if (type === 'L' || type === 'K') {
+      if (type === 'L') {
+        longName = tarString(content, 0, content.length);
+      } else {
+        longLinkName = tarString(content, 0, content.length);
+      }
+      continue;
+    }
+    if (type === 'x') {
+      pax = paxRecords(content);
+      continue;
+    }
+    if (type === 'g') {
+      continue;
+    }
+
+    const name = tarString(header, 0, 100);
+    const prefix = tarString(header, 257, 6).startsWith('ustar') ? tarString(header, 345, 155) : '';
+    const path = pax.path ?? longName ?? (prefix ? `${prefix}/${name}` : name);
+    const linkName = pax.linkpath ?? longLinkName ?? tarString(header, 157, 100);
+    longName = longLinkName = undefined;
+    pax = {};
+
+    const entryType = TAR_ENTRY_TYPES[type];
+    if (!entryType) {
+      continue; // devices and fifos are not source code
+    }
+
+    entries.push({
+      path,
+      type: entryType,
+      mode: tarNumber(header.subarray(100, 108)),
+      linkName,
+      content: () => content,
+    });
+  }
+
+  return entries;
+}
+
+function tarString(data: Buffer, start: number, length: number): string {
+  const field = data.subarray(start, start + length);
+  const end = field.indexOf(0);
+  return field.toString('utf-8', 0, end === -1 ? field.length : end);
+}
+
+function tarNumber(field: Buffer): number {
+  // GNU tar encodes large numbers in base-256, with the high bit of the first byte set
+  if (field[0] >= 0x80) {
+    return field.subarray(1).reduce((value, byte) => value * 256 + byte, field[0] - 0x80);
+  }
+  return parseInt(tarString(field, 0, field.length).trim() || '0', 8);
+}
+
+function paxRecords(content: Buffer): { [key: string]: string } {
+  const records: { [key: string]: string } = {};
+  let offset = 0;
+  while (offset < content.length) {
+    const space = content.indexOf(0x20, offset);
+    const length = parseInt(content.toString('utf-8', offset, space), 10);
+    if (space === -1 || !length) {
+      break;
+    }
+    // i.e: `30 path=some/long/path/to/file\n`
+    const record = content.toString('utf-8', space + 1, offset + length - 1);
+    const equals = record.indexOf('=');
+    records[record.slice(0, equals)] = record.slice(equals + 1);
+    offset += length;
+  }
+  return records;
+}
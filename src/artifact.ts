@@ -0,0 +1,171 @@
+import { cpSync, existsSync, readFileSync, statSync } from 'node:fs';
+import { basename, join } from 'node:path';
+import { inflateRawSync } from 'node:zlib';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { elfArchitecture, readElfHeader } from './elf';
+import { ResolvedInclude } from './include';
+
+/**
+ * The binary to copy from a prebuilt artifact.
+ */
+export interface PrebuiltArtifactOptions {
+  /**
+   * The name of the binary, to find it in the Cargo Lambda output directory. It's required for extensions.
+   */
+  readonly binaryName?: string;
+  readonly lambdaExtension?: boolean;
+  readonly architecture?: Architecture;
+  readonly include?: ResolvedInclude[];
+}
+
+/**
+ * Find the output of `cargo lambda build` for a binary.
+ *
+ * The root can be the zip file for the binary, the directory with the `bootstrap` file
+ * (or the `extensions` directory for extensions), or the Cargo Lambda output directory,
+ * i.e. `target/lambda`, with a directory for each binary.
+ */
+export function findPrebuiltArtifact(root: string, binaryName: string | undefined, extension?: boolean): string {
+  if (!existsSync(root)) {
+    throw new Error(`the prebuilt artifact \`${root}\` doesn't exist, run \`cargo lambda build\` before the synthesis, or choose a different bundling mode`);
+  }
+  if (statSync(root).isFile()) {
+    return root;
+  }
+
+  const entry = artifactEntry(binaryName, extension);
+  const candidates = extension
+    ? [root, join(root, 'extensions', `${binaryName}.zip`)]
+    : [root, join(root, 'bootstrap.zip'), ...binaryName ? [join(root, binaryName), join(root, binaryName, 'bootstrap.zip')] : []];
+
+  for (const candidate of candidates) {
+    if (candidate.endsWith('.zip') ? existsSync(candidate) : existsSync(join(candidate, entry))) {
+      return candidate;
+    }
+  }
+
+  if (!binaryName) {
+    throw new Error(`the prebuilt artifact \`${root}\` doesn't contain \`${entry}\`, set the option \`binaryName\` to find the binary in the Cargo Lambda output directory`);
+  }
+  throw new Error(`the prebuilt artifact \`${root}\` doesn't contain \`${entry}\` for the binary \`${binaryName}\``);
+}
+
+/**
+ * Check that a prebuilt artifact contains the binary, and that the binary was built for the architecture.
+ */
+export function verifyPrebuiltArtifact(artifact: string, binaryName: string | undefined, extension?: boolean, architecture?: Architecture) {
+  const entry = artifactEntry(binaryName, extension);
+  const binary = artifact.endsWith('.zip')
+    ? readZipEntry(artifact, entry)
+    : existsSync(join(artifact, entry)) ? readFileSync(join(artifact, entry)) : undefined;
+
+  if (binary === undefined) {
+    throw new Error(`the prebuilt artifact \`${artifact}\` doesn't contain \`${entry}\``);
+  }
+
+  const header = readElfHeader(binary);
+  if (!header) {
+    throw new Error(`\`${entry}\` in the prebuilt artifact \`${artifact}\` is not an ELF binary`);
+  }
+
+  const expected = architecture ?? Architecture.X86_64;
+  const actual = elfArchitecture(header);
+  if (actual?.name !== expected.name) {
+    throw new Error(`\`${entry}\` in the prebuilt artifact \`${artifact}\` was built for ${actual?.name ?? `the ELF machine ${header.machine}`}, but the architecture is ${expected.name}`);
+  }
+}
+
+/**
+ * Find the binary in a prebuilt artifact, verify it, and copy it to the output directory with the included files.
+ */
+export function copyPrebuiltArtifact(root: string, outputDir: string, options: PrebuiltArtifactOptions) {
+  const artifact = findPrebuiltArtifact(root, options.binaryName, options.lambdaExtension);
+  verifyPrebuiltArtifact(artifact, options.binaryName, options.lambdaExtension, options.architecture);
+
+  const include = options.include ?? [];
+  if (artifact.endsWith('.zip')) {
+    if (include.length > 0) {
+      throw new Error(`the option \`include\` is not supported with the zip artifact \`${artifact}\`, add the files to the zip file when it's built`);
+    }
+    cpSync(artifact, join(outputDir, basename(artifact)));
+    return;
+  }
+
+  if (options.lambdaExtension) {
+    const entry = artifactEntry(options.binaryName, true);
+    cpSync(join(artifact, entry), join(outputDir, entry));
+  } else {
+    cpSync(artifact, outputDir, { recursive: true });
+  }
+
+  for (const file of include) {
+    cpSync(file.source, join(outputDir, file.destination), { recursive: true });
+  }
+}
+
+function artifactEntry(binaryName: string | undefined, extension?: boolean): string {
+  return extension ? `extensions/${binaryName}` : 'bootstrap';
+}
+
+/**
+ * An entry in a zip file.
+ */
+export interface ZipEntry {
+  readonly name: string;
+  readonly size: number;
+  readonly compressedSize: number;
+  /**
+   * The Unix mode of the entry, when the zip file was created on Unix.
+   */
+  readonly unixMode?: number;
+  read(): Buffer;
+}
+
+/**
+ * List the entries in a zip file.
+ */
+export function readZipEntries(path: string): ZipEntry[] {
+  const data = readFileSync(path);
+
+  // the central directory is listed at the end of the file
+  const end = data.lastIndexOf(Buffer.from([0x50, 0x4b, 0x05, 0x06]));
+  if (end === -1) {
+    throw new Error(`\`${path}\` is not a zip file`);
+  }
+
+  const entries: ZipEntry[] = [];
+  let offset = data.readUInt32LE(end + 16);
+  for (let i = 0; i < data.readUInt16LE(end + 10); i++) {
+    const method = data.readUInt16LE(offset + 10);
+    const compressedSize = data.readUInt32LE(offset + 20);
+    const size = data.readUInt32LE(offset + 24);
+    const nameLength = data.readUInt16LE(offset + 28);
+    const extraLength = data.readUInt16LE(offset + 30);
+    const commentLength = data.readUInt16LE(offset + 32);
+    const externalAttributes = data.readUInt32LE(offset + 38);
+    const localHeader = data.readUInt32LE(offset + 42);
+    // the high byte of `version made by` is the host system, 3 is Unix
+    const unix = data.readUInt8(offset + 5) === 3;
+
+    entries.push({
+      name: data.toString('utf-8', offset + 46, offset + 46 + nameLength),
+      size,
+      compressedSize,
+      // the Unix mode is in the high 16 bits of the external attributes
+      unixMode: unix ? Math.floor(externalAttributes / 0x10000) : undefined,
+      read: () => {
+        const start = localHeader + 30 + data.readUInt16LE(localHeader + 26) + data.readUInt16LE(localHeader + 28);
+        const content = data.subarray(start, start + compressedSize);
+        return method === 0 ? content : inflateRawSync(content);
+      },
+    });
+
+    offset += 46 + nameLength + extraLength + commentLength;
+  }
+
+  return entries;
+}
+
+function readZipEntry(path: string, name: string): Buffer | undefined {
+  return readZipEntries(path).find(entry => entry.name === name)?.read();
+}
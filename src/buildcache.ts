@@ -0,0 +1,169 @@
+import { cpSync, existsSync, lstatSync, mkdirSync, readdirSync, renameSync, rmSync, statSync, utimesSync } from 'node:fs';
+import { basename, join, resolve } from 'node:path';
+import { Size } from 'aws-cdk-lib';
+import { Construct } from 'constructs';
+import { BuildCacheOptions } from './types';
+
+/**
+ * The context key that sets the build cache directory for all the constructs.
+ */
+export const BUILD_CACHE_CONTEXT = 'cargo-lambda-cdk:buildCache';
+
+const DEFAULT_MAX_SIZE = Size.gibibytes(5);
+
+// the entries are named after the fingerprint of the build, other files in the directory are ignored
+const ENTRY_NAME = /^[0-9a-f]{64}$/;
+
+/**
+ * A build output stored in the build cache.
+ */
+export interface BuildCacheEntry {
+  /**
+   * The fingerprint of the build.
+   */
+  readonly fingerprint: string;
+
+  /**
+   * The size of the output in bytes.
+   */
+  readonly size: number;
+
+  /**
+   * The last time that a synthesis stored or reused the output.
+   */
+  readonly lastUsed: Date;
+}
+
+/**
+ * The local cache of build outputs, shared by the CDK apps that use the same directory.
+ */
+export class BuildCache {
+  /**
+   * List the build outputs in the cache directory, the most recently used first.
+   */
+  public static list(directory: string): BuildCacheEntry[] {
+    const root = resolve(directory);
+    if (!existsSync(root)) {
+      return [];
+    }
+
+    return readdirSync(root, { withFileTypes: true })
+      .filter(entry => entry.isDirectory() && ENTRY_NAME.test(entry.name))
+      .map(entry => ({
+        fingerprint: entry.name,
+        size: diskUsage(join(root, entry.name)),
+        lastUsed: statSync(join(root, entry.name)).mtime,
+      }))
+      .sort((a, b) => b.lastUsed.getTime() - a.lastUsed.getTime());
+  }
+
+  /**
+   * Remove build outputs from the cache directory, and return the fingerprints of the removed outputs.
+   *
+   * @param directory the cache directory
+   * @param fingerprints the outputs to remove, all of them by default
+   */
+  public static clear(directory: string, fingerprints?: string[]): string[] {
+    const root = resolve(directory);
+    const removed = BuildCache.list(root)
+      .map(entry => entry.fingerprint)
+      .filter(fingerprint => !fingerprints || fingerprints.includes(fingerprint));
+
+    removed.forEach(fingerprint => rmSync(join(root, fingerprint), { recursive: true, force: true }));
+    return removed;
+  }
+}
+
+/**
+ * The build cache options of a construct, from the bundling options or the CDK context.
+ */
+export function buildCacheOptions(scope: Construct, options?: BuildCacheOptions): BuildCacheOptions | undefined {
+  if (options) {
+    return options;
+  }
+
+  const directory = scope.node.tryGetContext(BUILD_CACHE_CONTEXT);
+  return directory ? { directory } : undefined;
+}
+
+/**
+ * Copy the output of a build with the given fingerprint from the cache, and return false
+ * when the cache doesn't have it.
+ */
+export function restoreBuild(options: BuildCacheOptions, fingerprint: string, outputDir: string): boolean {
+  const entry = join(resolve(options.directory), fingerprint);
+  if (!existsSync(entry)) {
+    return false;
+  }
+
+  process.stderr.write(`Reusing the Rust build ${fingerprint.slice(0, 12)} from the build cache in ${options.directory}\n`);
+  cpSync(entry, outputDir, { recursive: true });
+  touch(entry);
+  return true;
+}
+
+/**
+ * Store the output of a build in the cache, and remove the least recently used outputs
+ * when the cache is bigger than its maximum size.
+ *
+ * A failure to store the output doesn't fail the synthesis, the output is only not cached.
+ */
+export function storeBuild(options: BuildCacheOptions, fingerprint: string, outputPath: string) {
+  const root = resolve(options.directory);
+  const entry = join(root, fingerprint);
+  if (existsSync(entry)) {
+    touch(entry);
+    return;
+  }
+
+  // copy into a different directory first, so other processes never reuse a partial output
+  const partialDir = join(root, `.partial-${fingerprint}-${process.pid}`);
+  try {
+    mkdirSync(root, { recursive: true });
+    if (statSync(outputPath).isFile()) { // the staged path of a single zip file is the file itself
+      mkdirSync(partialDir);
+      cpSync(outputPath, join(partialDir, basename(outputPath)));
+    } else {
+      cpSync(outputPath, partialDir, { recursive: true });
+    }
+    renameSync(partialDir, entry);
+  } catch (err) {
+    rmSync(partialDir, { recursive: true, force: true });
+    // the rename fails when another process stored the same build first
+    if (!existsSync(entry)) {
+      process.stderr.write(`Cannot store the Rust build ${fingerprint.slice(0, 12)} in the build cache: ${err}\n`);
+      return;
+    }
+  }
+
+  evict(root, (options.maxSize ?? DEFAULT_MAX_SIZE).toBytes(), fingerprint);
+}
+
+function evict(root: string, maxSize: number, keep: string) {
+  const entries = BuildCache.list(root);
+  let size = entries.reduce((total, entry) => total + entry.size, 0);
+
+  for (const entry of entries.reverse()) {
+    if (size <= maxSize) {
+      break;
+    }
+    if (entry.fingerprint === keep) {
+      continue;
+    }
+    rmSync(join(root, entry.fingerprint), { recursive: true, force: true });
+    size -= entry.size;
+  }
+}
+
+function touch(path: string) {
+  const now = new Date();
+  utimesSync(path, now, now);
+}
+
+function diskUsage(path: string): number {
+  const stats = lstatSync(path);
+  if (!stats.isDirectory()) {
+    return stats.size;
+  }
+  return readdirSync(path).reduce((total, name) => total + diskUsage(join(path, name)), 0);
+}
@@ -1,12 +1,25 @@
 /* eslint-disable no-console */
-import { spawnSync } from 'child_process';
-import { platform } from 'node:os';
-import { dirname } from 'node:path';
+import { cpSync, existsSync, statSync } from 'node:fs';
+import { platform, userInfo } from 'node:os';
+import { basename, dirname, join, posix, relative, resolve, sep, win32 } from 'node:path';
 import * as cdk from 'aws-cdk-lib';
-import { Architecture, AssetCode, Code } from 'aws-cdk-lib/aws-lambda';
-import { Manifest, getManifest } from './cargo';
-import { BundlingOptions } from './types';
-import { exec } from './util';
+import { Architecture, Code, CodeConfig, ResourceBindOptions } from 'aws-cdk-lib/aws-lambda';
+import * as s3_assets from 'aws-cdk-lib/aws-s3-assets';
+import { Construct } from 'constructs';
+import { copyPrebuiltArtifact } from './artifact';
+import { restoreBuild, storeBuild } from './buildcache';
+import { CargoProject, getBuildRoot, getCargoProject, getSourcePaths, resolveBinary } from './cargo';
+import { buildFingerprint, dockerImageIdentity, ignoredPaths, localToolchainIdentity } from './fingerprint';
+import { ResolvedInclude, resolveIncludes } from './include';
+import { PARALLEL_BUILDS_CONTEXT, PrebuildQueue } from './prebuild';
+import { BundlingProbe, compilerCommand, imageHasCommand, installedCargoLambdaVersion, localBuildProblems, localCompiler, validateCompilerArchitecture } from './probe';
+import { BuildJob } from './runner';
+import { dockerSccache, freeSccacheServerPort, localSccacheEnvironment, prebuildSccacheCommand, reportSccacheStats, sccacheStats } from './sccache';
+import { runtimeTarget, validateRuntimeCompiler, validateTarget } from './target';
+import { BundlingMode, BundlingOptions, Compiler, VerificationSeverity } from './types';
+import { exec, validateFeatures } from './util';
+import { reportFindings, verifyBundle } from './verify';
+import { dockerCacheMounts } from './volumes';
 
 /**
  * Options for bundling
@@ -29,140 +42,486 @@ export interface BundlingProps extends BundlingOptions {
    */
   readonly binaryName?: string;
 
+  /**
+   * The name of the workspace package that the binary belongs to.
+   */
+  readonly packageName?: string;
+
   /**
    * Whether the code to compile is a Lambda Extension or not.
    */
   readonly lambdaExtension?: boolean;
+
+  /**
+   * The names of several binaries to build in the same Cargo invocation.
+   * The output of each binary is placed in a directory with the binary's name.
+   */
+  readonly binaryNames?: string[];
+
+  /**
+   * The format of the output, `binary` or `zip`.
+   */
+  readonly outputFormat?: string;
+
+  /**
+   * The Lambda runtime that runs the binary, it sets the newest GLIBC version that the binary can require.
+   */
+  readonly runtime?: string;
+
+  /**
+   * Whether the output is packaged in a container image, which doesn't have the size limits of zip packages.
+   */
+  readonly containerImage?: boolean;
 }
 
 interface CommandOptions {
   readonly inputDir: string;
   readonly outputDir: string;
+  readonly packageName?: string;
   readonly binaryName?: string;
+  readonly binaryNames?: string[];
   readonly osPlatform: NodeJS.Platform;
   readonly architecture?: Architecture;
   readonly lambdaExtension?: boolean;
   readonly cargoLambdaFlags: string[];
   readonly profile: string;
-  readonly manifest: Manifest;
+  readonly compiler?: string;
+  readonly target?: string;
+  readonly runtime?: string;
+  readonly include?: ResolvedInclude[];
+  readonly outputFormat?: string;
+  readonly features?: string[];
+  readonly noDefaultFeatures?: boolean;
+  readonly allFeatures?: boolean;
+  readonly project: CargoProject;
 }
 
 /**
  * Bundling
  */
 export class Bundling implements cdk.BundlingOptions {
-  public static bundle(options: BundlingProps): AssetCode {
-    const projectRoot = dirname(options.manifestPath);
-    const bundling = new Bundling(projectRoot, options);
-
-    return Code.fromAsset(projectRoot, {
-      assetHashType: options.assetHashType ?? cdk.AssetHashType.OUTPUT,
-      assetHash: options.assetHash,
-      bundling: {
-        image: bundling.image,
-        command: bundling.command,
-        environment: bundling.environment,
-        local: bundling.local,
-        // Overwrite properties which are defined from the docker options.
-        ...Object.fromEntries(
-          Object.entries(options.dockerOptions ?? {}).filter(
-            ([_, value]) => value !== undefined,
-          ),
-        ),
-      },
+  public static bundle(options: BundlingProps): Code {
+    const bundling = new Bundling(Bundling.projectRoot(options), options);
+    return new RustAssetCode(options, bundling);
+  }
+
+  /**
+   * Build the binary and stage it in the cloud assembly without wrapping it as Lambda code,
+   * so it can be packaged in other formats, like container images.
+   */
+  public static stage(scope: Construct, id: string, options: BundlingProps): cdk.AssetStaging {
+    const bundling = new Bundling(Bundling.projectRoot(options), options);
+    const deferred = bundling.deferBuild(scope);
+    const staging = new cdk.AssetStaging(scope, id, {
+      sourcePath: bundling.projectRoot,
+      ...Bundling.assetOptions(bundling, options, cdk.Stack.of(scope).bundlingRequired),
     });
+    if (deferred) {
+      deferredStagings.add(staging);
+    }
+    verifyStaging(scope, staging.absoluteStagedPath, options, bundling);
+    return staging;
+  }
+
+  /**
+   * Whether the build of the staged asset runs in the prebuild phase, it's also true when
+   * the asset was already built and staged by a previous synthesis.
+   */
+  public static isDeferred(staging: cdk.AssetStaging): boolean {
+    return deferredStagings.has(staging);
   }
 
   public static clearRunsLocallyCache(): void { // for tests
-    this.runsLocally = undefined;
+    BundlingProbe.clearCache();
+  }
+
+  /**
+   * The directory used as the asset source, and mounted in the Docker container.
+   * It includes the package, its workspace and path dependencies, the included files must be inside it.
+   * For prebuilt artifacts, it's the directory that contains the artifact.
+   */
+  private static projectRoot(options: BundlingProps): string {
+    if (options.prebuiltArtifact) {
+      return dirname(resolve(options.prebuiltArtifact));
+    }
+    return getBuildRoot(options.manifestPath);
+  }
+
+  /**
+   * The options of the asset. The fingerprint is only calculated when the stack is bundled,
+   * CDK doesn't hash the assets of the other stacks.
+   */
+  public static assetOptions(bundling: Bundling, options: BundlingProps, bundlingRequired: boolean): cdk.AssetOptions {
+    // The fingerprint is the asset hash, so an asset that was staged with the same fingerprint is reused without building
+    const fingerprint = bundlingRequired && options.assetHashType === undefined && options.assetHash === undefined
+      ? bundling.fingerprint
+      : undefined;
+
+    return {
+      assetHashType: fingerprint ? cdk.AssetHashType.CUSTOM : options.assetHashType ?? cdk.AssetHashType.OUTPUT,
+      assetHash: fingerprint ?? options.assetHash,
+      bundling: Bundling.bundlingOptions(bundling, options),
+    };
   }
 
-  private static runsLocally?: boolean;
+  /**
+   * The bundling options of the asset, the Docker options override the options of the build.
+   */
+  private static bundlingOptions(bundling: Bundling, options: BundlingProps): cdk.BundlingOptions {
+    return {
+      image: bundling.image,
+      command: bundling.command,
+      environment: bundling.environment,
+      local: bundling.local,
+      workingDirectory: bundling.workingDirectory,
+      // Overwrite properties which are defined from the docker options.
+      ...Object.fromEntries(
+        Object.entries(options.dockerOptions ?? {}).filter(
+          ([_, value]) => value !== undefined,
+        ),
+      ),
+      // The cache volumes are mounted with the volumes from the docker options.
+      ...(bundling.volumes ? { volumes: [...bundling.volumes, ...options.dockerOptions?.volumes ?? []] } : {}),
+    };
+  }
 
   // Core bundling options
   public readonly image: cdk.DockerImage;
   public readonly command: string[];
   public readonly environment?: { [key: string]: string };
   public readonly local?: cdk.ILocalBundling;
+  public readonly workingDirectory?: string;
+  public readonly volumes?: cdk.DockerVolume[];
+
+  // the local or Docker build, to run it in the prebuild phase
+  private readonly deferredBuild?: (id: string, outputDir: string) => BuildJob;
+  private prebuildQueue?: PrebuildQueue;
+  private readonly calculateFingerprint?: () => string;
+  private calculatedFingerprint?: string;
 
   constructor(readonly projectRoot: string, private readonly props: BundlingProps) {
-    Bundling.runsLocally = Bundling.runsLocally
-      ?? cargoLambdaVersion()
-      ?? false;
+    const mode = bundlingMode(props);
+    if (props.target) {
+      validateTarget(props.target, props.architecture, props.runtime);
+    }
+    if (mode !== BundlingMode.PREBUILT) {
+      validateRuntimeCompiler(props.runtime, props.compiler, props.target);
+      validateCompilerArchitecture(props.compiler, props.architecture);
+    }
 
     // Docker bundling
-    const shouldBuildImage = props.forcedDockerBundling || !Bundling.runsLocally;
+    const shouldBuildImage = mode === BundlingMode.AUTO || mode === BundlingMode.DOCKER;
 
     this.image = shouldBuildImage
       ? props.dockerImage ?? cdk.DockerImage.fromRegistry('ghcr.io/cargo-lambda/cargo-lambda')
       : cdk.DockerImage.fromRegistry('dummy'); // Do not build if we don't need to
 
-    const manifest = getManifest(props.manifestPath);
+    const packageDir = dirname(props.manifestPath);
+    // the sources of a build must be in the directory that is mounted in Docker, a prebuilt artifact is copied locally
+    const includes = resolveIncludes(packageDir, props.include ?? [], props.prebuiltArtifact ? undefined : projectRoot);
+
+    // A prebuilt artifact is copied without reading the Cargo project, so it doesn't need the sources
+    if (mode === BundlingMode.PREBUILT && props.prebuiltArtifact) {
+      this.command = [];
+      this.local = prebuiltBundling(resolve(props.prebuiltArtifact), props.binaryNames ?? [props.binaryName], props, includes);
+      return;
+    }
+
+    const project = getCargoProject(props.manifestPath);
+    const targetDir = resolve(packageDir, props.environment?.CARGO_TARGET_DIR ?? process.env.CARGO_TARGET_DIR ?? join(project.workspaceRoot, 'target'));
+
+    // The project root can be a parent directory of the package, when the package is part
+    // of a workspace or has path dependencies. Cargo Lambda always runs in the package's directory.
+    const relativePackageDir = relative(projectRoot, packageDir).split(sep).join(posix.sep);
+    const dockerIncludes = includes.map(include => ({
+      ...include,
+      source: posix.join(cdk.AssetStaging.BUNDLING_INPUT_DIR, relative(projectRoot, include.source).split(sep).join(posix.sep)),
+    }));
+
+    const packageName = props.packageName
+      ?? (relativePackageDir && !props.binaryNames ? project.package?.name : undefined);
+    const dockerInputDir = posix.join(cdk.AssetStaging.BUNDLING_INPUT_DIR, relativePackageDir);
+    if (relativePackageDir) {
+      this.workingDirectory = dockerInputDir;
+    }
 
     const cargoLambdaFlags = props.cargoLambdaFlags ?? [];
     const profile = props.profile ?? 'release';
 
     const osPlatform = platform();
+
+    const crossInDefaultImage = props.compiler === Compiler.CROSS && !props.dockerImage;
+    if (mode === BundlingMode.DOCKER && crossInDefaultImage) {
+      throw new Error(CROSS_IN_DEFAULT_IMAGE);
+    }
+    // The compiler is only switched automatically for local builds, we cannot know the architecture of the Docker host
+    const dockerCompiler = props.compiler === Compiler.AUTO ? undefined : props.compiler;
+    const hostCompiler = localCompiler(props.compiler, props.architecture, props.runtime);
+
     const bundlingCommand = this.createBundlingCommand({
-      osPlatform,
-      manifest,
+      osPlatform: 'linux', // the Docker command always runs in a Linux container
+      project,
       cargoLambdaFlags,
       profile,
+      compiler: dockerCompiler,
       outputDir: cdk.AssetStaging.BUNDLING_OUTPUT_DIR,
-      inputDir: cdk.AssetStaging.BUNDLING_INPUT_DIR,
+      inputDir: dockerInputDir,
+      packageName,
       binaryName: props.binaryName,
+      binaryNames: props.binaryNames,
       architecture: props.architecture,
       lambdaExtension: props.lambdaExtension,
+      features: props.features,
+      noDefaultFeatures: props.noDefaultFeatures,
+      allFeatures: props.allFeatures,
+      target: props.target,
+      runtime: props.runtime,
+      include: dockerIncludes,
+      outputFormat: props.outputFormat,
     });
 
-    this.command = ['bash', '-c', bundlingCommand];
-    this.environment = props.environment;
-
-    //Local bundling
-    if (!props.forcedDockerBundling) { // only if Docker is not forced
-      const createLocalCommand = (outputDir: string) => {
-        return this.createBundlingCommand({
-          osPlatform,
-          manifest,
-          outputDir,
-          cargoLambdaFlags,
-          profile,
-          inputDir: projectRoot,
-          binaryName: props.binaryName,
-          architecture: props.architecture,
-          lambdaExtension: props.lambdaExtension,
-        });
-      };
+    // the Docker build compiles the crates with sccache when the image has it installed
+    const dockerCompilerCache = shouldBuildImage && props.compilerCache ? dockerSccache(props.compilerCache) : undefined;
+    this.command = ['bash', '-c', dockerCompilerCache
+      ? chain([dockerCompilerCache.setup, bundlingCommand, dockerCompilerCache.report])
+      : bundlingCommand];
+
+    const dockerCache = shouldBuildImage ? dockerCacheMounts(projectRoot, props.dockerCache) : undefined;
+    const dockerEnvironment = { ...dockerCache?.environment, ...dockerCompilerCache?.environment };
+    this.environment = Object.keys(dockerEnvironment).length > 0 ? { ...dockerEnvironment, ...props.environment } : props.environment;
+    const volumes = [...dockerCache?.volumes ?? [], ...dockerCompilerCache ? [dockerCompilerCache.volume] : []];
+    this.volumes = volumes.length > 0 ? volumes : undefined;
+    const prepareDockerCache = () => {
+      dockerCache?.prepare(this.image.image, props.dockerOptions?.user);
+      dockerCompilerCache?.prepare();
+    };
+    // the default image has the tools of the compilers that it supports, a custom image is checked before the build
+    const checkDockerCompiler = () => {
+      const command = props.dockerImage && dockerCompiler ? compilerCommand(dockerCompiler) : undefined;
+      if (command && imageHasCommand(this.image.image, command) === false) {
+        throw new Error(`the compiler \`${dockerCompiler}\` needs \`${command}\`, which is not installed in the Docker image \`${this.image.image}\``);
+      }
+    };
+    const reuseBuild = (outputDir: string) => !!this.fingerprint
+      && (reuseFinishedBuild(this.fingerprint, outputDir)
+        || (!!props.buildCache && restoreBuild(props.buildCache, this.fingerprint, outputDir)));
+    const deferToPrebuild = (outputDir: string) => {
+      this.prebuildQueue?.defer(outputDir);
+      return !!this.prebuildQueue;
+    };
+    // CDK copies the files in and out of a Docker volume instead of a bind mount with this option
+    const deferDockerBuild = (outputDir: string) => props.dockerOptions?.bundlingFileAccess !== cdk.BundlingFileAccess.VOLUME_COPY
+      && deferToPrebuild(outputDir);
+    const dockerBuild = (id: string, outputDir: string): BuildJob =>
+      dockerBuildJob(id, Bundling.bundlingOptions(this, props), projectRoot, outputDir, join(project.workspaceRoot, 'target'));
+
+    const probeOptions = {
+      architecture: props.architecture,
+      compiler: props.compiler,
+      target: props.target,
+      runtime: props.runtime,
+    };
+
+    if (mode !== BundlingMode.PREBUILT) {
+      this.calculateFingerprint = () => buildFingerprint(projectRoot, [
+        ...getSourcePaths(props.manifestPath, !!props.binaryNames),
+        ...includes.map(include => include.source),
+      ], {
+        command: this.command,
+        environment: this.environment,
+        toolchain: mode === BundlingMode.DOCKER
+          || (mode === BundlingMode.AUTO && localBuildProblems(BundlingProbe.run(probeOptions), props.probeLocalToolchain).length > 0)
+          ? {
+            image: dockerImageIdentity(this.image.image),
+            dockerOptions: Object.fromEntries(Object.entries(props.dockerOptions ?? {}).filter(([key]) => key !== 'local')),
+          }
+          : localToolchainIdentity(hostCompiler),
+      }, ignoredPaths(project.workspaceRoot, targetDir));
+    }
 
+    if (mode === BundlingMode.DOCKER) {
+      this.deferredBuild = dockerBuild;
+      // Local bundling runs first, it only checks that Docker is available before the Docker build
       this.local = {
         tryBundle(outputDir: string) {
-          if (Bundling.runsLocally == false) {
-            process.stderr.write('Rust build cannot run locally. Switching to Docker bundling.\n');
-            return false;
+          if (reuseBuild(outputDir)) {
+            return true;
           }
 
-          const localCommand = createLocalCommand(outputDir);
-          exec(
-            osPlatform === 'win32' ? 'cmd' : 'bash',
-            [
-              osPlatform === 'win32' ? '/c' : '-c',
-              localCommand,
-            ],
-            {
-              env: { ...process.env, ...props.environment ?? {} },
-              stdio: [ // show output
-                'ignore', // ignore stdio
-                process.stderr, // redirect stdout to stderr
-                'inherit', // inherit stderr
-              ],
-              cwd: projectRoot,
-              windowsVerbatimArguments: osPlatform === 'win32',
-            },
-          );
-          return true;
+          const capabilities = BundlingProbe.run(probeOptions);
+          if (!capabilities.dockerAvailable) {
+            throw new Error(`cannot bundle with Docker: ${capabilities.dockerProblems.join(', ')}`);
+          }
+          checkDockerCompiler();
+          prepareDockerCache();
+          return deferDockerBuild(outputDir);
         },
       };
+      return;
+    }
+
+    if (mode === BundlingMode.PREBUILT) {
+      const artifactRoot = join(process.env.CARGO_TARGET_DIR ?? join(project.workspaceRoot, 'target'), 'lambda');
+      const binaryNames = props.binaryNames
+        ?? [resolveBinary(project, props.binaryName, packageName).name];
+      // Cargo Lambda names the output directories after the binaries, without the package
+      for (const binaryName of binaryNames) {
+        const packages = project.packages.filter(pkg => binaryName && pkg.binaries.includes(binaryName)).map(pkg => pkg.name);
+        if (packages.length > 1) {
+          throw new Error(`the binary \`${binaryName}\` is declared by the packages ${packages.join(', ')}, so its output in \`${artifactRoot}\` can belong to any of them, use the option \`prebuiltArtifact\` with the output of the package`);
+        }
+      }
+      this.local = prebuiltBundling(artifactRoot, binaryNames, props, includes);
+      return;
     }
+
+    //Local bundling
+    const createLocalCommand = (outputDir: string) => {
+      return this.createBundlingCommand({
+        osPlatform,
+        project,
+        outputDir,
+        cargoLambdaFlags,
+        profile,
+        compiler: hostCompiler,
+        inputDir: packageDir,
+        packageName,
+        binaryName: props.binaryName,
+        binaryNames: props.binaryNames,
+        architecture: props.architecture,
+        lambdaExtension: props.lambdaExtension,
+        features: props.features,
+        noDefaultFeatures: props.noDefaultFeatures,
+        allFeatures: props.allFeatures,
+        target: props.target,
+        runtime: props.runtime,
+        include: includes,
+        outputFormat: props.outputFormat,
+      });
+    };
+
+    const localBuild = (id: string, outputDir: string): BuildJob => ({
+      id,
+      command: osPlatform === 'win32' ? 'cmd' : 'bash',
+      args: [
+        osPlatform === 'win32' ? '/c' : '-c',
+        createLocalCommand(outputDir),
+      ],
+      cwd: packageDir,
+      env: {
+        ...process.env,
+        ...props.compilerCache ? localSccacheEnvironment(props.compilerCache, { ...process.env, ...props.environment }) : {},
+        ...props.environment ?? {},
+      },
+      windowsVerbatimArguments: osPlatform === 'win32',
+      lockDir: targetDir,
+    });
+    // the builds of the prebuild phase that run at the same time have their own sccache server,
+    // so each build reports its own statistics
+    const prebuildLocalBuild = (id: string, outputDir: string): BuildJob => {
+      const build = localBuild(id, outputDir);
+      if (build.env.RUSTC_WRAPPER !== 'sccache' || osPlatform === 'win32') {
+        return build;
+      }
+      return {
+        ...build,
+        args: [build.args[0], prebuildSccacheCommand(build.args[1])],
+        env: { ...build.env, SCCACHE_SERVER_PORT: `${freeSccacheServerPort(build.env.SCCACHE_DIR ?? '', id)}` },
+      };
+    };
+
+    // the build of a staged asset that is waiting for it runs with Docker when it cannot run locally
+    this.deferredBuild = (id: string, outputDir: string) =>
+      localBuildProblems(BundlingProbe.run(probeOptions), props.probeLocalToolchain).length > 0
+        ? dockerBuild(id, outputDir)
+        : prebuildLocalBuild(id, outputDir);
+
+    this.local = {
+      tryBundle(outputDir: string) {
+        if (reuseBuild(outputDir)) {
+          return true;
+        }
+
+        const capabilities = BundlingProbe.run(probeOptions);
+        const localProblems = localBuildProblems(capabilities, props.probeLocalToolchain);
+        if (localProblems.length > 0) {
+          if (mode === BundlingMode.LOCAL) {
+            throw new Error(`cannot bundle locally: ${localProblems.join(', ')}`);
+          }
+          if (!capabilities.dockerAvailable) {
+            throw new Error('cannot bundle locally or with Docker. '
+              + `Local bundling: ${localProblems.join(', ')}. `
+              + `Docker bundling: ${capabilities.dockerProblems.join(', ')}. `
+              + 'Fix one of them, or use the bundling mode `prebuilt` with the output of a previous build');
+          }
+          if (crossInDefaultImage) {
+            throw new Error(CROSS_IN_DEFAULT_IMAGE);
+          }
+
+          process.stderr.write(`Rust build cannot run locally: ${localProblems.join(', ')}. Switching to Docker bundling.\n`);
+          checkDockerCompiler();
+          prepareDockerCache();
+          return deferDockerBuild(outputDir);
+        }
+
+        if (deferToPrebuild(outputDir)) {
+          return true;
+        }
+
+        const build = localBuild(packageDir, outputDir);
+        const compilerCache = build.env.RUSTC_WRAPPER === 'sccache';
+        const statsBefore = compilerCache ? sccacheStats(build.env) : undefined;
+        exec(build.command, build.args, {
+          env: build.env,
+          stdio: [ // show output
+            'ignore', // ignore stdio
+            process.stderr, // redirect stdout to stderr
+            'inherit', // inherit stderr
+          ],
+          cwd: build.cwd,
+          windowsVerbatimArguments: build.windowsVerbatimArguments,
+        });
+        reportSccacheStats(statsBefore, compilerCache ? sccacheStats(build.env) : undefined);
+        return true;
+      },
+    };
+  }
+
+  /**
+   * The fingerprint of the build, from the sources, the build settings and the toolchain.
+   * It's undefined for prebuilt artifacts, and it's calculated the first time that it's used.
+   */
+  public get fingerprint(): string | undefined {
+    if (this.calculatedFingerprint === undefined && this.calculateFingerprint) {
+      this.calculatedFingerprint = this.calculateFingerprint();
+    }
+    return this.calculatedFingerprint;
+  }
+
+  /**
+   * Defer the build to the prebuild phase of the stage, when it's enabled. The build can only
+   * be deferred when the asset hash is the fingerprint, and the output is a directory, a single
+   * zip file is staged as the asset itself.
+   */
+  public deferBuild(scope: Construct): boolean {
+    const fingerprintHash = this.props.assetHashType === undefined && this.props.assetHash === undefined
+      && cdk.Stack.of(scope).bundlingRequired && !!this.fingerprint;
+    const directoryOutput = this.props.outputFormat !== 'zip' || !!this.props.binaryNames;
+    if (fingerprintHash && directoryOutput && !this.props.containerImage) {
+      this.prebuildQueue = PrebuildQueue.of(scope);
+    }
+    return !!this.prebuildQueue;
+  }
+
+  /**
+   * Build the binary in an asset that was staged with a deferred build, in the prebuild phase.
+   */
+  public buildStagedAsset(scope: Construct, stagedPath: string, finish: () => void) {
+    const queue = this.prebuildQueue ?? PrebuildQueue.of(scope);
+    if (!queue || !this.deferredBuild || !this.fingerprint) {
+      throw new Error(`the asset \`${stagedPath}\` is waiting for a build in the prebuild phase, enable it with the context key \`${PARALLEL_BUILDS_CONTEXT}\`, or remove the asset and synthesize again`);
+    }
+    queue.add(this.fingerprint, this.deferredBuild(scope.node.path, stagedPath), stagedPath, finish);
   }
 
   public createBundlingCommand(props: CommandOptions): string {
@@ -188,41 +547,85 @@ export class Bundling implements cdk.BundlingOptions {
       buildBinary.push('--extension');
     }
 
-    if (props.architecture && !props.cargoLambdaFlags.includes('--target')) {
+    if (props.compiler && !props.cargoLambdaFlags.includes('--compiler')) {
+      buildBinary.push('--compiler');
+      buildBinary.push(props.compiler);
+    }
+
+    const target = props.target ?? runtimeTarget(props.runtime, props.architecture, props.compiler);
+    if (target && !props.cargoLambdaFlags.includes('--target')) {
+      buildBinary.push('--target');
+      buildBinary.push(target);
+    } else if (props.architecture && !props.cargoLambdaFlags.includes('--target')) {
       const targetFlag = props.architecture.name == Architecture.ARM_64.name ? '--arm64' : '--x86-64';
       buildBinary.push(targetFlag);
     }
 
-    let packageName;
-    if (props.binaryName) {
-      buildBinary.push('--bin');
-      buildBinary.push(props.binaryName);
-      packageName = props.binaryName;
-    } else {
-      if (props.manifest.workspace) {
-        throw new Error('the Cargo manifest is a workspace, use the option `binaryName` to specify the binary to build');
+    // Cargo Lambda can only include files in zip files, and it doesn't support
+    // custom destinations, any other include is copied after the build.
+    const copyCommands: string[] = [];
+    for (const include of props.include ?? []) {
+      if (props.outputFormat === 'zip') {
+        const source = posix.relative(props.inputDir.split(sep).join(posix.sep), include.source.split(sep).join(posix.sep));
+        if (source !== include.destination) {
+          throw new Error(`the include destination \`${include.destination}\` is not supported with the zip output format, files are included with the same path as their source`);
+        }
+        buildBinary.push('--include');
+        buildBinary.push(source);
+        continue;
       }
 
-      packageName = props.manifest.package?.name;
-      if (props.manifest.bin) {
-        if (props.manifest.bin.length == 1) {
-          packageName = props.manifest.bin[0].name;
-
-          buildBinary.push('--bin');
-          buildBinary.push(packageName);
-        } else {
-          throw new Error('there are more than one binaries declared in this Cargo package, use the option `binaryName` to specify the binary to build');
-        }
+      const outputDirs = props.binaryNames
+        ? props.binaryNames.map(binaryName => joinPath(props.osPlatform, props.outputDir, binaryName))
+        : [props.outputDir];
+      for (const outputDir of outputDirs) {
+        copyCommands.push(copyCommand(props.osPlatform, include, outputDir));
       }
+    }
+
+    if (props.outputFormat && !props.cargoLambdaFlags.includes('--output-format')) {
+      buildBinary.push('--output-format');
+      buildBinary.push(props.outputFormat);
+    }
+
+    validateFeatures(props);
+    if (props.allFeatures) {
+      buildBinary.push('--all-features');
+    }
+    if (props.features && props.features.length > 0) {
+      buildBinary.push('--features');
+      buildBinary.push(props.features.join(','));
+    }
+
+    if (props.noDefaultFeatures) {
+      buildBinary.push('--no-default-features');
+    }
 
-      if (!packageName) {
-        throw new Error('the Cargo package is missing the package name or a [[bin]] section, use the option `binaryName` to specify the binary to build');
+    if (props.packageName) {
+      buildBinary.push('--package');
+      buildBinary.push(props.packageName);
+    }
+
+    // `--flatten` takes the name of a binary that the command builds, so with `--package`
+    // it's the binary of that package, even when other packages have a binary with the same name
+    let flattenBinary;
+    if (props.binaryNames) {
+      for (const binaryName of props.binaryNames) {
+        buildBinary.push('--bin');
+        buildBinary.push(binaryName);
       }
+    } else {
+      const binary = resolveBinary(props.project, props.binaryName, props.packageName);
+      if (binary.select) {
+        buildBinary.push('--bin');
+        buildBinary.push(binary.name);
+      }
+      flattenBinary = binary.name;
     }
 
-    if (!props.lambdaExtension && packageName) {
+    if (!props.lambdaExtension && flattenBinary) {
       buildBinary.push('--flatten');
-      buildBinary.push(packageName);
+      buildBinary.push(flattenBinary);
     }
 
     const command = buildBinary.concat(props.cargoLambdaFlags).join(' ');
@@ -230,20 +633,225 @@ export class Bundling implements cdk.BundlingOptions {
     return chain([
       ...this.props.commandHooks?.beforeBundling(props.inputDir, props.outputDir) ?? [],
       command,
+      ...copyCommands,
       ...this.props.commandHooks?.afterBundling(props.inputDir, props.outputDir) ?? [],
     ]);
   }
 }
 
+/**
+ * Lambda code with the Rust bundle. The asset is created when the code is bound, so the fingerprint
+ * is only calculated when the stack is bundled, and the bundle is verified once it's staged.
+ */
+class RustAssetCode extends Code {
+  /**
+   * The source of the asset, the directory with the package and its dependencies.
+   */
+  public readonly path: string;
+  private asset?: s3_assets.Asset;
+
+  constructor(private readonly bundlingProps: BundlingProps, private readonly bundling: Bundling) {
+    super();
+    this.path = bundling.projectRoot;
+  }
+
+  public bind(scope: Construct): CodeConfig {
+    if (!this.asset) {
+      this.bundling.deferBuild(scope);
+      const bundlingRequired = cdk.Stack.of(scope).bundlingRequired;
+      this.asset = new s3_assets.Asset(scope, 'Code', {
+        path: this.path,
+        deployTime: true,
+        ...bundlingRequired ? this.options : Bundling.assetOptions(this.bundling, this.bundlingProps, false),
+      });
+      verifyStaging(scope, resolve(cdk.Stage.of(scope)?.outdir ?? '', this.asset.assetPath), this.bundlingProps, this.bundling);
+    } else if (cdk.Stack.of(this.asset) !== cdk.Stack.of(scope)) {
+      throw new Error(`the Rust code is already used in the stack \`${cdk.Stack.of(this.asset).node.path}\`, create a new code for each stack`);
+    }
+
+    return {
+      s3Location: {
+        bucketName: this.asset.s3BucketName,
+        objectKey: this.asset.s3ObjectKey,
+      },
+    };
+  }
+
+  public bindToResource(resource: cdk.CfnResource, options: ResourceBindOptions = {}) {
+    if (!this.asset) {
+      throw new Error('bindToResource() must be called after bind()');
+    }
+    this.asset.addResourceMetadata(resource, options.resourceProperty ?? 'Code');
+  }
+
+  /**
+   * The options of the asset when the stack is bundled.
+   */
+  private get options(): cdk.AssetOptions {
+    return Bundling.assetOptions(this.bundling, this.bundlingProps, true);
+  }
+}
+
+/**
+ * Verify the staged bundle, and store it in the build cache once it's verified,
+ * which also stores the output of Docker builds. The bundles of deferred builds
+ * are verified after the prebuild phase.
+ */
+function verifyStaging(scope: Construct, stagedPath: string, props: BundlingProps, bundling: Bundling) {
+  if (!cdk.Stack.of(scope).bundlingRequired) {
+    return; // bundling was skipped for this stack
+  }
+
+  if (PrebuildQueue.isPending(stagedPath)) {
+    bundling.buildStagedAsset(scope, stagedPath, () => verifyBuild(scope, stagedPath, props, bundling.fingerprint));
+    return;
+  }
+  verifyBuild(scope, stagedPath, props, bundling.fingerprint);
+}
+
+// the staged assets of Bundling.stage whose build is deferred to the prebuild phase
+const deferredStagings = new WeakSet<cdk.AssetStaging>();
+
+// the staged outputs of the builds that finished in this process, by fingerprint
+const finishedBuilds = new Map<string, string>();
+
+/**
+ * Copy the output of an identical build that already finished in this process. The builds are
+ * identical when they have the same fingerprint, even when their assets are hashed by their output.
+ */
+function reuseFinishedBuild(fingerprint: string, outputDir: string): boolean {
+  const stagedPath = finishedBuilds.get(fingerprint);
+  if (!stagedPath || !existsSync(stagedPath) || PrebuildQueue.isPending(stagedPath)) {
+    return false;
+  }
+
+  process.stderr.write(`Reusing the Rust build ${fingerprint.slice(0, 12)} from ${stagedPath}\n`);
+  if (statSync(stagedPath).isFile()) { // the staged path of a single zip file is the file itself
+    cpSync(stagedPath, join(outputDir, basename(stagedPath)));
+  } else {
+    cpSync(stagedPath, outputDir, { recursive: true });
+  }
+  return true;
+}
+
+function verifyBuild(scope: Construct, stagedPath: string, props: BundlingProps, fingerprint?: string) {
+  if (props.verification !== VerificationSeverity.IGNORE) {
+    reportFindings(scope, verifyBundle(stagedPath, {
+      architecture: props.architecture,
+      runtime: props.runtime,
+      lambdaExtension: props.lambdaExtension,
+      binaryNames: props.binaryNames,
+      containerImage: props.containerImage,
+    }), props.verification);
+  }
+
+  if (fingerprint) {
+    finishedBuilds.set(fingerprint, stagedPath);
+  }
+  if (props.buildCache && fingerprint) {
+    storeBuild(props.buildCache, fingerprint, stagedPath);
+  }
+}
+
+/**
+ * The Docker build as a build of the prebuild phase, it runs the container like CDK does
+ * when it bundles an asset with a bind mount.
+ */
+function dockerBuildJob(id: string, options: cdk.BundlingOptions, sourcePath: string, outputDir: string, lockDir: string): BuildJob {
+  const user = userInfo();
+  const volumes: cdk.DockerVolume[] = [
+    { hostPath: sourcePath, containerPath: cdk.AssetStaging.BUNDLING_INPUT_DIR },
+    { hostPath: outputDir, containerPath: cdk.AssetStaging.BUNDLING_OUTPUT_DIR },
+    ...options.volumes ?? [],
+  ];
+  const [entrypoint, ...entrypointArgs] = options.entrypoint ?? [];
+
+  return {
+    id,
+    command: process.env.CDK_DOCKER ?? 'docker',
+    args: [
+      'run', '--rm',
+      ...options.securityOpt ? ['--security-opt', options.securityOpt] : [],
+      ...options.network ? ['--network', options.network] : [],
+      '-u', options.user ?? (user.uid !== -1 ? `${user.uid}:${user.gid}` : '1000:1000'),
+      ...(options.volumesFrom ?? []).flatMap(container => ['--volumes-from', container]),
+      ...volumes.flatMap(volume => ['-v', `${volume.hostPath}:${volume.containerPath}:${volume.consistency ?? cdk.DockerVolumeConsistency.DELEGATED}`]),
+      ...Object.entries(options.environment ?? {}).flatMap(([key, value]) => ['--env', `${key}=${value}`]),
+      '-w', options.workingDirectory ?? cdk.AssetStaging.BUNDLING_INPUT_DIR,
+      ...entrypoint ? ['--entrypoint', entrypoint] : [],
+      options.image.image,
+      ...entrypointArgs,
+      ...options.command ?? [],
+    ],
+    cwd: sourcePath,
+    env: process.env,
+    lockDir,
+  };
+}
+
 function chain(commands: string[]): string {
   return commands.filter(c => !!c).join(' && ');
 }
 
+function joinPath(osPlatform: NodeJS.Platform, ...paths: string[]): string {
+  return osPlatform === 'win32' ? win32.join(...paths) : posix.join(...paths);
+}
+
+function copyCommand(osPlatform: NodeJS.Platform, include: ResolvedInclude, outputDir: string): string {
+  if (osPlatform === 'win32') {
+    const destination = win32.join(outputDir, ...include.destination.split(posix.sep));
+    return include.directory
+      ? `xcopy /e /i /y /q "${include.source}" "${destination}"`
+      : `(if not exist "${win32.dirname(destination)}" mkdir "${win32.dirname(destination)}") && copy /y "${include.source}" "${destination}"`;
+  }
+
+  const destination = posix.join(outputDir, include.destination);
+  return `mkdir -p "${posix.dirname(destination)}" && cp -R "${include.source}" "${destination}"`;
+}
+
+/**
+ * Copy the binaries from a prebuilt artifact instead of building them.
+ */
+function prebuiltBundling(
+  artifactRoot: string,
+  binaryNames: (string | undefined)[],
+  props: BundlingProps,
+  include: ResolvedInclude[],
+): cdk.ILocalBundling {
+  return {
+    tryBundle(outputDir: string) {
+      for (const binaryName of binaryNames) {
+        copyPrebuiltArtifact(artifactRoot, props.binaryNames && binaryName ? join(outputDir, binaryName) : outputDir, {
+          binaryName,
+          lambdaExtension: props.lambdaExtension,
+          architecture: props.architecture,
+          include,
+        });
+      }
+      return true;
+    },
+  };
+}
+
 export function cargoLambdaVersion(): boolean | undefined {
-  try {
-    const cargo = spawnSync('cargo', ['lambda', '--version']);
-    return cargo.status !== 0 || cargo.error ? undefined : true;
-  } catch (err) {
-    return undefined;
+  return installedCargoLambdaVersion() !== undefined ? true : undefined;
+}
+
+const CROSS_IN_DEFAULT_IMAGE = 'the compiler `cross` is not available in the default Docker image, use the option `dockerImage` to provide an image with `cross` installed, or choose a different compiler';
+
+function bundlingMode(props: BundlingProps): BundlingMode {
+  if (props.forcedDockerBundling && props.mode && props.mode !== BundlingMode.DOCKER) {
+    throw new Error(`the option \`forcedDockerBundling\` conflicts with the bundling mode \`${props.mode}\``);
+  }
+  if (props.prebuiltArtifact && props.mode && props.mode !== BundlingMode.PREBUILT) {
+    throw new Error(`the option \`prebuiltArtifact\` conflicts with the bundling mode \`${props.mode}\``);
+  }
+  if (props.forcedDockerBundling && props.prebuiltArtifact) {
+    throw new Error('the option `forcedDockerBundling` conflicts with the option `prebuiltArtifact`');
+  }
+
+  if (props.prebuiltArtifact) {
+    return BundlingMode.PREBUILT;
   }
+  return props.mode ?? (props.forcedDockerBundling ? BundlingMode.DOCKER : BundlingMode.AUTO);
 }
@@ -1,9 +1,12 @@
-import { mkdirSync, existsSync, readFileSync, rmSync } from 'node:fs';
-import { join, parse } from 'node:path';
-import { tmpdir } from 'os';
+import { spawnSync } from 'child_process';
+import { existsSync, readdirSync, readFileSync, realpathSync, statSync } from 'node:fs';
+import { homedir } from 'node:os';
+import { dirname, join, parse, posix, relative, resolve, sep } from 'node:path';
 import { load } from 'js-toml';
-import { BundlingOptions } from './types';
-import { exec } from './util';
+import { Construct } from 'constructs';
+import { extractSourceArchive } from './archive';
+import { addSparseCheckoutDirs, checkoutGitSource, gitLockOptions } from './git';
+import { BundlingOptions, GitOptions, GitProvenance } from './types';
 
 /**
  * Base properties for a Cargo project.
@@ -11,63 +14,145 @@ import { exec } from './util';
  * RustFunctionProps and RustExtensionProps cannot inherit from this interface
  * because jsii only supports direct inheritance from a single interface.
  */
-interface CargoProject {
+interface CargoProjectProps {
   readonly bundling?: BundlingOptions;
   readonly binaryName?: string;
   readonly manifestPath?: string;
+  readonly sourceArchive?: string;
   readonly gitRemote?: string;
   readonly gitReference?: string;
   readonly gitForceClone?: boolean;
+  readonly gitOptions?: GitOptions;
 }
 
 export interface Workspace {
   members: string[];
+  exclude?: string[];
 }
 
 export interface Package {
   name: string;
+  'default-run'?: string;
+  'autobins'?: boolean;
+}
+
+export interface Binary {
+  name: string;
 }
 
 export interface Manifest {
   package?: Package;
-  bin?: Package[];
+  bin?: Binary[];
   workspace?: Workspace;
 }
 
-export function getManifestPath(project: CargoProject): string {
-  const defaultManifestPath = project.manifestPath || 'Cargo.toml';
-  let manifestPath = defaultManifestPath;
+/**
+ * A package in a Cargo project, with the binaries that it declares.
+ */
+export interface CargoPackage {
+  readonly name: string;
+  readonly manifestPath: string;
+  readonly binaries: string[];
+  readonly defaultRun?: string;
+}
 
-  // Determine what type of URL this is and download (git repo) locally
-  if (project.gitRemote && isValidGitUrl(project.gitRemote)) {
-    const gitReference = project.gitReference || 'HEAD';
+/**
+ * The packages and binaries in a Cargo project.
+ */
+export interface CargoProject {
+  /**
+   * The package declared by the manifest, undefined when the manifest is a virtual workspace.
+   */
+  readonly package?: CargoPackage;
 
-    // i.e: 3ed81b4751e8f09bfa39fe743ee143df60304db5        HEAD
-    let latestCommit = exec('git', ['ls-remote', project.gitRemote, gitReference]).stdout.toString().split(/(\s+)/)[0];
-    const localPath = join(tmpdir(), latestCommit);
+  /**
+   * All the packages in the workspace, or only the package declared by the manifest
+   * when it's not part of a workspace.
+   */
+  readonly packages: CargoPackage[];
 
-    if (project.gitForceClone) {
-      rmSync(localPath, { recursive: true, force: true });
-    }
+  /**
+   * Whether the manifest is the root of a workspace.
+   */
+  readonly isWorkspace: boolean;
 
-    if (!existsSync(localPath)) {
-      mkdirSync(localPath, { recursive: true });
+  /**
+   * The directory with the Cargo.toml file of the workspace that this manifest belongs to.
+   * It's the same directory as the manifest's when the package is not part of a workspace.
+   */
+  readonly workspaceRoot: string;
 
-      const args = ['clone'];
-      if (gitReference === 'HEAD') {
-        args.push('--depth', '1');
-      }
+  /**
+   * Whether the project was resolved with `cargo metadata`. When it's false,
+   * the project was resolved by reading the Cargo.toml files, and some binaries
+   * could be missing.
+   */
+  readonly fromMetadata: boolean;
+}
 
-      args.push(project.gitRemote, localPath);
-      exec('git', args);
+/**
+ * The source of a Cargo project.
+ */
+export interface CargoSource {
+  readonly manifestPath: string;
 
-      if (gitReference !== 'HEAD') {
-        exec('git', ['checkout', gitReference], { cwd: localPath });
-      }
-    }
+  /**
+   * The commit that the project was checked out from, when the project is in a git repository.
+   */
+  readonly gitProvenance?: GitProvenance;
+}
+
+/**
+ * The path to the Cargo.toml file of the project, cloning the git repository when the project is remote.
+ *
+ * The scope is used to read the git lock options from the CDK context.
+ */
+export function getManifestPath(project: CargoProjectProps, scope?: Construct): string {
+  return getCargoSource(project, scope).manifestPath;
+}
+
+/**
+ * The Cargo.toml file of the project, and the commit that it was checked out from when the project is remote.
+ *
+ * The project can be in a local directory, in a source archive, or in a git repository.
+ */
+export function getCargoSource(project: CargoProjectProps, scope?: Construct): CargoSource {
+  const defaultManifestPath = project.manifestPath || 'Cargo.toml';
+  let manifestPath = defaultManifestPath;
+  let gitProvenance: GitProvenance | undefined;
+
+  if (project.sourceArchive && project.gitRemote) {
+    throw new Error('the options `sourceArchive` and `gitRemote` cannot be used together, choose one source for the Cargo project');
+  }
+
+  if (project.sourceArchive) {
+    manifestPath = join(extractSourceArchive(project.sourceArchive), defaultManifestPath);
+  }
+
+  // Download the git repository locally
+  if (project.gitRemote) {
+    const { path: localPath, provenance } = checkoutGitSource({
+      remote: project.gitRemote,
+      reference: project.gitReference,
+      forceClone: project.gitForceClone,
+      options: project.gitOptions,
+      lock: gitLockOptions(scope),
+    });
+    gitProvenance = provenance;
 
     // Append Cargo.toml to the path
     manifestPath = join(localPath, defaultManifestPath);
+
+    if (project.gitOptions?.sparseCheckout) {
+      const packageManifest = parse(manifestPath).ext ? manifestPath : join(manifestPath, 'Cargo.toml');
+      let dirs = [dirname(packageManifest)];
+      // each directory that is checked out can declare more path dependencies
+      while (addSparseCheckoutDirs(localPath, relativeDirs(localPath, dirs), project.gitOptions)) {
+        // the projects resolved before don't have the packages that were just checked out
+        forgetCargoProjects(localPath);
+        dirs = sparseCheckoutDirs(packageManifest);
+      }
+    }
   }
 
   let manifestPathResult;
@@ -85,18 +170,456 @@ export function getManifestPath(project: CargoProject): string {
     throw new Error(`'${manifestPathResult}' is not a path to a Cargo.toml file, use the option \`manifestPath\` to specify the location of the Cargo.toml file`);
   }
 
-  return manifestPathResult;
-}
-
-function isValidGitUrl(url: string): boolean {
-  const httpsRegex = /^https:\/\/[\w.-]+(:\d+)?\/[\w.-]+\/[\w.-]+(\.git)?$/;
-  const sshRegex = /^ssh:\/\/[\w.-]+@[\w.-]+(:\d+)?\/[\w.-]+\/[\w.-]+(\.git)?$/;
-  const gitSshRegex = /^git@[\w.-]+:[\w.-]+\/[\w.-]+(\.git)?$/;
-
-  return httpsRegex.test(url) || sshRegex.test(url) || gitSshRegex.test(url);
+  return { manifestPath: manifestPathResult, gitProvenance };
 }
 
 export function getManifest(manifestPath: string): Manifest {
   const data = readFileSync(manifestPath);
   return load(data.toString('utf-8')) as Manifest;
 }
+
+// the projects already resolved in this process, by manifest path and modification time
+const cargoProjects = new Map<string, CargoProject>();
+
+/**
+ * Resolve the packages and binaries in a Cargo project.
+ *
+ * It uses `cargo metadata` when Cargo is installed, and falls back
+ * to reading the Cargo.toml files otherwise. The project is only resolved
+ * once per manifest, unless the manifest changes.
+ */
+export function getCargoProject(manifestPath: string): CargoProject {
+  const path = resolve(manifestPath);
+  const key = `${path}:${existsSync(path) ? statSync(path).mtimeMs : ''}`;
+  let project = cargoProjects.get(key);
+  if (!project) {
+    project = cargoMetadata(path) ?? cargoProjectFromManifest(path);
+    cargoProjects.set(key, project);
+  }
+  return project;
+}
+
+/**
+ * Forget the projects resolved in a directory, when files were added to it without changing
+ * the manifests that were already resolved, i.e. the members of a workspace.
+ */
+function forgetCargoProjects(dir: string) {
+  for (const key of [...cargoProjects.keys()]) {
+    if (key.startsWith(resolve(dir) + sep)) {
+      cargoProjects.delete(key);
+    }
+  }
+}
+
+interface MetadataTarget {
+  name: string;
+  kind: string[];
+}
+
+interface MetadataPackage {
+  name: string;
+  manifest_path: string;
+  targets: MetadataTarget[];
+  default_run?: string | null;
+}
+
+interface Metadata {
+  packages: MetadataPackage[];
+  workspace_root: string;
+}
+
+function cargoMetadata(manifestPath: string): CargoProject | undefined {
+  let metadata: Metadata;
+  try {
+    const proc = spawnSync('cargo', [
+      'metadata',
+      '--format-version', '1',
+      '--no-deps',
+      '--offline',
+      '--manifest-path', manifestPath,
+    ]);
+    if (proc.error || proc.status !== 0) {
+      return undefined;
+    }
+    metadata = JSON.parse(proc.stdout.toString('utf-8'));
+  } catch (err) {
+    return undefined;
+  }
+
+  const manifestRealPath = realpathSync(manifestPath);
+  const packages = metadata.packages.map((pkg): CargoPackage => ({
+    name: pkg.name,
+    manifestPath: pkg.manifest_path,
+    binaries: pkg.targets.filter(t => t.kind.includes('bin')).map(t => t.name),
+    defaultRun: pkg.default_run ?? undefined,
+  }));
+
+  return {
+    package: packages.find(pkg => existsSync(pkg.manifestPath) && realpathSync(pkg.manifestPath) === manifestRealPath),
+    packages,
+    isWorkspace: !!getManifest(manifestPath).workspace,
+    workspaceRoot: metadata.workspace_root,
+    fromMetadata: true,
+  };
+}
+
+function cargoProjectFromManifest(manifestPath: string): CargoProject {
+  const manifest = getManifest(manifestPath);
+  const pkg = cargoPackageFromManifest(manifestPath, manifest);
+  const workspaceRoot = manifest.workspace ? dirname(manifestPath) : findWorkspaceRoot(manifestPath);
+
+  // the packages of the workspace, also when the manifest is one of its members
+  const packages = pkg ? [pkg] : [];
+  const workspace = workspaceRoot === dirname(manifestPath) ? manifest.workspace : getManifest(join(workspaceRoot, 'Cargo.toml')).workspace;
+  if (workspace) {
+    const excluded = (workspace.exclude ?? []).map(e => join(workspaceRoot, e));
+    const members = (workspace.members ?? [])
+      .flatMap(member => expandGlob(workspaceRoot, member))
+      .filter(dir => !excluded.includes(dir) && existsSync(join(dir, 'Cargo.toml')));
+
+    for (const member of members) {
+      const memberManifestPath = join(member, 'Cargo.toml');
+      const memberPackage = cargoPackageFromManifest(memberManifestPath, getManifest(memberManifestPath));
+      if (memberPackage && !packages.some(p => p.name === memberPackage.name)) {
+        packages.push(memberPackage);
+      }
+    }
+  }
+
+  return {
+    package: pkg,
+    packages,
+    isWorkspace: !!manifest.workspace,
+    workspaceRoot,
+    fromMetadata: false,
+  };
+}
+
+function findWorkspaceRoot(manifestPath: string): string {
+  const packageDir = dirname(manifestPath);
+
+  let dir = dirname(packageDir);
+  while (dir !== dirname(dir)) {
+    const candidate = join(dir, 'Cargo.toml');
+    if (existsSync(candidate)) {
+      const workspace = getManifest(candidate).workspace;
+      if (workspace) {
+        const excluded = (workspace.exclude ?? []).map(e => join(dir, e));
+        const isMember = (workspace.members ?? [])
+          .flatMap(member => expandGlob(dir, member))
+          .some(member => member === packageDir && !excluded.includes(member));
+        return isMember ? dir : packageDir;
+      }
+    }
+    dir = dirname(dir);
+  }
+
+  return packageDir;
+}
+
+function cargoPackageFromManifest(manifestPath: string, manifest: Manifest): CargoPackage | undefined {
+  // The package name cannot be inherited from the workspace, but ignore anything that's not a string
+  if (!manifest.package || typeof manifest.package.name !== 'string') {
+    return undefined;
+  }
+
+  const root = dirname(manifestPath);
+  const binaries = (manifest.bin ?? []).map(bin => bin.name);
+
+  if (manifest.package.autobins !== false) {
+    if (existsSync(join(root, 'src', 'main.rs'))) {
+      binaries.push(manifest.package.name);
+    }
+
+    const binDir = join(root, 'src', 'bin');
+    if (existsSync(binDir)) {
+      for (const entry of readdirSync(binDir, { withFileTypes: true })) {
+        if (entry.isFile() && entry.name.endsWith('.rs')) {
+          binaries.push(entry.name.slice(0, -'.rs'.length));
+        } else if (entry.isDirectory() && existsSync(join(binDir, entry.name, 'main.rs'))) {
+          binaries.push(entry.name);
+        }
+      }
+    }
+  }
+
+  return {
+    name: manifest.package.name,
+    manifestPath,
+    binaries: binaries.filter((name, index) => binaries.indexOf(name) === index),
+    defaultRun: manifest.package['default-run'],
+  };
+}
+
+function expandGlob(root: string, pattern: string): string[] {
+  let paths = [root];
+  for (const segment of pattern.split('/')) {
+    if (!segment.includes('*') && !segment.includes('?')) {
+      paths = paths.map(p => join(p, segment));
+      continue;
+    }
+
+    const matcher = new RegExp('^' + segment.replace(/[.+^${}()|[\]\\]/g, '\\$&').replace(/\*/g, '.*').replace(/\?/g, '.') + '$');
+    paths = paths.flatMap(p => existsSync(p)
+      ? readdirSync(p, { withFileTypes: true })
+        .filter(entry => entry.isDirectory() && matcher.test(entry.name))
+        .map(entry => join(p, entry.name))
+      : []);
+  }
+  return paths;
+}
+
+/**
+ * The binary to build, and whether it must be selected with `--bin`.
+ */
+export interface ResolvedBinary {
+  readonly name: string;
+  readonly select: boolean;
+}
+
+/**
+ * Find the binary to build in a Cargo project.
+ *
+ * If the binary name is not provided, the package must only have one binary,
+ * or declare a `default-run` binary. If the package name is provided, the binary
+ * must belong to that package.
+ */
+export function resolveBinary(project: CargoProject, binaryName?: string, packageName?: string): ResolvedBinary {
+  let pkg = project.isWorkspace ? undefined : project.package;
+  if (packageName) {
+    pkg = project.packages.find(p => p.name === packageName);
+    if (!pkg) {
+      const packages = project.packages.map(p => p.name);
+      throw new Error(`the package \`${packageName}\` was not found in the Cargo workspace.${didYouMean(packageName, packages)} Packages found: ${packages.join(', ')}`);
+    }
+  }
+
+  const available = pkg ? pkg.binaries : project.packages.flatMap(p => p.binaries);
+
+  if (binaryName) {
+    if (project.fromMetadata && !available.includes(binaryName)) {
+      throw new Error(`the binary \`${binaryName}\` was not found in the Cargo project.${didYouMean(binaryName, available)}${binariesFound(available)}`);
+    }
+    return { name: binaryName, select: true };
+  }
+
+  if (!pkg) {
+    throw new Error(`the Cargo manifest is a workspace, use the option \`binaryName\` to specify the binary to build.${binariesFound(available)}`);
+  }
+
+  if (pkg.defaultRun) {
+    return { name: pkg.defaultRun, select: available.length > 1 };
+  }
+
+  if (available.length > 1) {
+    throw new Error(`there are more than one binaries declared in this Cargo package, use the option \`binaryName\` to specify the binary to build.${binariesFound(available)}`);
+  }
+
+  if (available.length === 1) {
+    return { name: available[0], select: false };
+  }
+
+  throw new Error('the Cargo package is missing the package name or a [[bin]] section, use the option `binaryName` to specify the binary to build');
+}
+
+function binariesFound(binaries: string[]): string {
+  return binaries.length > 0 ? ` Binaries found: ${binaries.join(', ')}` : '';
+}
+
+function didYouMean(name: string, candidates: string[]): string {
+  const suggestions = candidates
+    .map(candidate => ({ candidate, distance: editDistance(name, candidate) }))
+    .filter(({ distance }) => distance <= Math.max(2, Math.floor(name.length / 3)))
+    .sort((a, b) => a.distance - b.distance)
+    .map(({ candidate }) => `\`${candidate}\``);
+
+  return suggestions.length > 0 ? ` Did you mean ${suggestions.join(' or ')}?` : '';
+}
+
+function editDistance(a: string, b: string): number {
+  let previous = Array.from({ length: b.length + 1 }, (_, i) => i);
+  for (let i = 1; i <= a.length; i++) {
+    const current = [i];
+    for (let j = 1; j <= b.length; j++) {
+      const cost = a[i - 1] === b[j - 1] ? 0 : 1;
+      current.push(Math.min(previous[j] + 1, current[j - 1] + 1, previous[j - 1] + cost));
+    }
+    previous = current;
+  }
+  return previous[b.length];
+}
+
+// how far above the workspace root the path dependencies can be
+const MAX_BUILD_ROOT_LEVELS = 3;
+
+/**
+ * Find the directory that must be available to build a package.
+ *
+ * It includes the root of the workspace that the package belongs to,
+ * and all the path dependencies of the packages in the workspace,
+ * even when they live outside the workspace. The directory is mounted in
+ * the Docker container and hashed, so it fails when the directory is the
+ * home directory, the filesystem root, or too far above the workspace.
+ */
+export function getBuildRoot(manifestPath: string): string {
+  const workspaceRoot = getCargoProject(manifestPath).workspaceRoot;
+  const workspaceManifest = join(workspaceRoot, 'Cargo.toml');
+
+  const manifests = [workspaceManifest, manifestPath];
+  for (const pkg of getCargoProject(workspaceManifest).packages) {
+    manifests.push(pkg.manifestPath);
+  }
+
+  const seen = new Set<string>();
+  const dirs = [workspaceRoot];
+  while (manifests.length > 0) {
+    const current = resolve(manifests.pop()!);
+    if (seen.has(current) || !existsSync(current)) {
+      continue;
+    }
+    seen.add(current);
+
+    for (const dependency of pathDependencies(current)) {
+      dirs.push(dependency);
+      manifests.push(join(dependency, 'Cargo.toml'));
+    }
+  }
+
+  const buildRoot = commonAncestor(dirs.map(dir => resolve(dir)));
+  const levels = relative(buildRoot, resolve(workspaceRoot)).split(sep).filter(Boolean).length;
+  const home = resolve(homedir());
+  if (levels > MAX_BUILD_ROOT_LEVELS || buildRoot === dirname(buildRoot) || buildRoot === home || home.startsWith(buildRoot + sep)) {
+    const outside = dirs.map(dir => resolve(dir)).filter(dir => !dir.startsWith(resolve(workspaceRoot) + sep) && dir !== resolve(workspaceRoot));
+    throw new Error(`the path dependencies ${outside.map(dir => `\`${dir}\``).join(', ')} are too far from the workspace \`${workspaceRoot}\`, the directory \`${buildRoot}\` that contains them all would be mounted and hashed as the asset source, move them to at most ${MAX_BUILD_ROOT_LEVELS} levels above the workspace`);
+  }
+  return buildRoot;
+}
+
+function pathDependencies(manifestPath: string): string[] {
+  const manifest = load(readFileSync(manifestPath).toString('utf-8')) as { [key: string]: any };
+  const tables = [manifest, manifest.workspace ?? {}, ...Object.values(manifest.target ?? {})];
+
+  const paths: string[] = [];
+  for (const table of tables) {
+    for (const key of ['dependencies', 'dev-dependencies', 'build-dependencies']) {
+      for (const dependency of Object.values(table[key] ?? {}) as any[]) {
+        if (typeof dependency?.path === 'string') {
+          paths.push(join(dirname(manifestPath), dependency.path));
+        }
+      }
+    }
+  }
+  return paths;
+}
+
+/**
+ * Find the directories that must be checked out to build a package: the package's directory,
+ * the workspace root, the workspace members and the path dependencies.
+ *
+ * The workspace members are resolved from the directories before the first wildcard,
+ * because the directories that a member glob matches may not be checked out yet.
+ */
+export function sparseCheckoutDirs(manifestPath: string): string[] {
+  const workspaceRoot = getCargoProject(manifestPath).workspaceRoot;
+  const workspaceManifest = join(workspaceRoot, 'Cargo.toml');
+
+  const dirs = [dirname(manifestPath), workspaceRoot];
+  const manifests = [manifestPath, workspaceManifest];
+  if (existsSync(workspaceManifest)) {
+    for (const member of getManifest(workspaceManifest).workspace?.members ?? []) {
+      const segments = member.split('/');
+      const wildcard = segments.findIndex(segment => segment.includes('*') || segment.includes('?'));
+      dirs.push(join(workspaceRoot, ...(wildcard === -1 ? segments : segments.slice(0, wildcard))));
+    }
+    manifests.push(...getCargoProject(workspaceManifest).packages.map(pkg => pkg.manifestPath));
+  }
+
+  const seen = new Set<string>();
+  while (manifests.length > 0) {
+    const current = resolve(manifests.pop()!);
+    if (seen.has(current) || !existsSync(current)) {
+      continue;
+    }
+    seen.add(current);
+
+    for (const dependency of pathDependencies(current)) {
+      dirs.push(dependency);
+      manifests.push(join(dependency, 'Cargo.toml'));
+    }
+  }
+
+  return dirs.map(dir => resolve(dir)).filter((dir, index, all) => all.indexOf(dir) === index);
+}
+
+/**
+ * The directories relative to the repository root in the format of `git sparse-checkout`,
+ * without the directories outside the repository.
+ */
+function relativeDirs(root: string, dirs: string[]): string[] {
+  return dirs
+    .map(dir => relative(root, dir))
+    .filter(dir => dir !== '..' && !dir.startsWith('..' + sep))
+    .map(dir => dir.split(sep).join(posix.sep));
+}
+
+/**
+ * The files that configure the build in the root of a workspace.
+ */
+const WORKSPACE_BUILD_FILES = ['Cargo.toml', 'Cargo.lock', 'rust-toolchain', 'rust-toolchain.toml', '.cargo/config', '.cargo/config.toml'];
+
+/**
+ * Find the directories and files that the build of a package reads: the package's directory,
+ * its path dependencies, and the files that configure the build in the workspace root.
+ *
+ * With `allMembers`, or when the manifest is a virtual workspace, the directories of all
+ * the workspace members are included, for the builds that compile any member of the workspace.
+ */
+export function getSourcePaths(manifestPath: string, allMembers?: boolean): string[] {
+  const project = getCargoProject(manifestPath);
+  const workspaceRoot = project.workspaceRoot;
+  const workspaceManifest = join(workspaceRoot, 'Cargo.toml');
+
+  // the directory of a virtual workspace only has the workspace configuration,
+  // and the directory of a package at the workspace root has its sources
+  const packageManifests = project.package ? [manifestPath] : [];
+  if (allMembers || !project.package) {
+    packageManifests.push(...getCargoProject(workspaceManifest).packages.map(pkg => pkg.manifestPath));
+  }
+
+  const manifests = [...packageManifests, workspaceManifest];
+  const paths = [
+    ...packageManifests.map(manifest => dirname(manifest)),
+    ...WORKSPACE_BUILD_FILES.map(file => join(workspaceRoot, file)),
+  ];
+
+  const seen = new Set<string>();
+  while (manifests.length > 0) {
+    const current = resolve(manifests.pop()!);
+    if (seen.has(current) || !existsSync(current)) {
+      continue;
+    }
+    seen.add(current);
+
+    for (const dependency of pathDependencies(current)) {
+      paths.push(dependency);
+      manifests.push(join(dependency, 'Cargo.toml'));
+    }
+  }
+
+  return paths
+    .map(path => resolve(path))
+    .filter((path, index, all) => all.indexOf(path) === index && existsSync(path));
+}
+
+/**
+ * Find the deepest directory that contains all the given paths.
+ */
+export function commonAncestor(dirs: string[]): string {
+  let ancestor = dirs[0];
+  for (const dir of dirs.slice(1)) {
+    while (dir !== ancestor && !dir.startsWith(ancestor.endsWith(sep) ? ancestor : ancestor + sep)) {
+      ancestor = dirname(ancestor);
+    }
+  }
+  return ancestor;
+}
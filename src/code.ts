@@ -0,0 +1,68 @@
+import { dirname, resolve } from 'node:path';
+import { AssetHashType, DockerImage } from 'aws-cdk-lib';
+import { Architecture, Code } from 'aws-cdk-lib/aws-lambda';
+import { copyPrebuiltArtifact } from './artifact';
+
+/**
+ * Options for `RustCode.fromCargoLambdaOutput`.
+ */
+export interface CargoLambdaOutputOptions {
+  /**
+   * The architecture that the binary must be built for.
+   *
+   * @default Architecture.X86_64
+   */
+  readonly architecture?: Architecture;
+
+  /**
+   * The name of the binary, to find it when the path is the Cargo Lambda output directory,
+   * i.e. `target/lambda`, or the name of the extension.
+   *
+   * @default - the path is the zip file, or the directory with the `bootstrap` binary
+   */
+  readonly binaryName?: string;
+
+  /**
+   * Whether the output is a Lambda extension, in `extensions/<binaryName>`.
+   *
+   * @default false
+   */
+  readonly lambdaExtension?: boolean;
+}
+
+/**
+ * Lambda code for Rust binaries.
+ */
+export class RustCode {
+  /**
+   * The output of a previous `cargo lambda build`, i.e. in a different CI job, as Lambda code.
+   * The Cargo sources are not needed to deploy it.
+   *
+   * The binary is checked when the asset is staged: the synthesis fails if the output doesn't
+   * contain the `bootstrap` binary, or the extension, or if the binary is not an ELF binary
+   * for the architecture.
+   *
+   * @param path a zip file, a directory with the `bootstrap` binary, or the Cargo Lambda output directory
+   */
+  public static fromCargoLambdaOutput(path: string, options: CargoLambdaOutputOptions = {}): Code {
+    if (options.lambdaExtension && !options.binaryName) {
+      throw new Error('the option `binaryName` is required for the output of an extension');
+    }
+
+    const artifactRoot = resolve(path);
+    return Code.fromAsset(dirname(artifactRoot), {
+      assetHashType: AssetHashType.OUTPUT,
+      bundling: {
+        image: DockerImage.fromRegistry('dummy'), // the output is only copied
+        local: {
+          tryBundle(outputDir: string) {
+            copyPrebuiltArtifact(artifactRoot, outputDir, options);
+            return true;
+          },
+        },
+      },
+    });
+  }
+
+  private constructor() {}
+}
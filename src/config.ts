@@ -0,0 +1,241 @@
+import { existsSync, readFileSync } from 'node:fs';
+import { dirname, join } from 'node:path';
+import { Annotations, Duration } from 'aws-cdk-lib';
+import { IRole, Role } from 'aws-cdk-lib/aws-iam';
+import { FunctionOptions, ILayerVersion, LayerVersion, Tracing } from 'aws-cdk-lib/aws-lambda';
+import { Construct } from 'constructs';
+import { load } from 'js-toml';
+import { BundlingProps } from './bundling';
+import { BuildSetting, BuildSettingSource, BundlingOptions, Compiler } from './types';
+
+/**
+ * Deploy settings that Cargo Lambda reads from `[package.metadata.lambda.deploy]`
+ * in Cargo.toml, or from the `[deploy]` section in CargoLambda.toml.
+ */
+export interface DeployConfig {
+  memory?: number;
+  timeout?: number;
+  tracing?: string;
+  role?: string;
+  layers?: string[];
+  env?: { [key: string]: string };
+  env_file?: string;
+  description?: string;
+}
+
+/**
+ * Read the Cargo Lambda deploy settings for the package in `manifestPath`.
+ *
+ * Settings in CargoLambda.toml take precedence over settings in Cargo.toml.
+ */
+export function getDeployConfig(manifestPath: string): DeployConfig {
+  const manifest = readToml(manifestPath);
+  const config = readToml(join(dirname(manifestPath), 'CargoLambda.toml'));
+
+  return {
+    ...manifest?.package?.metadata?.lambda?.deploy,
+    ...config?.deploy,
+  };
+}
+
+/**
+ * Build settings that Cargo Lambda reads from `[package.metadata.lambda.build]`
+ * in Cargo.toml, or from the `[build]` section in CargoLambda.toml.
+ */
+export interface BuildConfig {
+  compiler?: string | { type?: string };
+  target?: string;
+  include?: string[];
+  features?: string[];
+  output_format?: string;
+  profile?: string;
+}
+
+/**
+ * Bundling options resolved from the construct's bundling options
+ * and the Cargo Lambda build settings, with the source of each setting.
+ */
+export interface ResolvedBuildConfig {
+  readonly bundling: Omit<BundlingProps, 'manifestPath'>;
+  readonly settings: BuildSetting[];
+}
+
+/**
+ * Merge the Cargo Lambda build settings for the package in `manifestPath` into the bundling options.
+ *
+ * The precedence order, from highest to lowest, is: the bundling options,
+ * the `[build]` section in CargoLambda.toml, and `[package.metadata.lambda.build]` in Cargo.toml.
+ */
+export function resolveBuildConfig(manifestPath: string, bundling: BundlingOptions): ResolvedBuildConfig {
+  const sources: [BuildSettingSource, BuildConfig][] = [
+    [BuildSettingSource.CARGO_LAMBDA_TOML, readToml(join(dirname(manifestPath), 'CargoLambda.toml'))?.build ?? {}],
+    [BuildSettingSource.CARGO_TOML, readToml(manifestPath)?.package?.metadata?.lambda?.build ?? {}],
+  ];
+
+  const settings: BuildSetting[] = [];
+  const resolve = <T>(name: string, explicit: T | undefined, read: (config: BuildConfig) => T | undefined): T | undefined => {
+    if (explicit !== undefined) {
+      settings.push({ name, value: settingValue(explicit), source: BuildSettingSource.BUNDLING_OPTIONS });
+      return explicit;
+    }
+    for (const [source, config] of sources) {
+      const value = read(config);
+      if (value !== undefined) {
+        settings.push({ name, value: settingValue(value), source });
+        return value;
+      }
+    }
+    return undefined;
+  };
+
+  const bundlingProps = bundling as Omit<BundlingProps, 'manifestPath'>;
+  return {
+    bundling: {
+      ...bundling,
+      compiler: resolve('compiler', bundling.compiler, config => compilerFromConfig(config.compiler)),
+      // all the features are already activated, the features of the configuration cannot be added
+      features: bundling.allFeatures ? bundling.features : resolve('features', bundling.features, config => config.features),
+      profile: resolve('profile', bundling.profile, config => config.profile),
+      target: resolve('target', bundling.target, config => config.target),
+      include: resolve('include', bundling.include, config => config.include?.map(source => ({ source }))),
+      outputFormat: resolve('outputFormat', bundlingProps.outputFormat, config => config.output_format),
+    },
+    settings,
+  };
+}
+
+function compilerFromConfig(compiler?: string | { type?: string }): Compiler | undefined {
+  const type = typeof compiler === 'string' ? compiler : compiler?.type;
+  if (type === undefined) {
+    return undefined;
+  }
+
+  // `auto` is only an option of the constructs, Cargo Lambda doesn't have it
+  const valid = Object.values(Compiler).filter(value => value !== Compiler.AUTO) as string[];
+  if (!valid.includes(type)) {
+    throw new Error(`invalid compiler \`${type}\` in the Cargo Lambda build settings, valid compilers are: ${valid.join(', ')}`);
+  }
+  return type as Compiler;
+}
+
+function settingValue(value: unknown): string {
+  if (Array.isArray(value)) {
+    return value.map(item => item?.source !== undefined
+      ? item.source + (item.destination !== undefined ? `:${item.destination}` : '')
+      : String(item)).join(',');
+  }
+  return String(value);
+}
+
+/**
+ * Function options from the Cargo Lambda deploy settings,
+ * and the options that were overridden by the explicit props.
+ */
+export interface DeployDefaults {
+  readonly options: FunctionOptions;
+  readonly conflicts: string[];
+}
+
+/**
+ * Convert the Cargo Lambda deploy settings into function options.
+ *
+ * The explicit props always win, each prop that overrides a different
+ * value in the deploy settings is reported as a conflict.
+ */
+export function deployDefaults(scope: Construct, id: string, manifestPath: string, props: FunctionOptions): DeployDefaults {
+  const config = getDeployConfig(manifestPath);
+  const conflicts: string[] = [];
+
+  const pick = <T>(prop: string, setting: string, explicit: T | undefined, value: T | undefined, display?: (v: T) => string): T | undefined => {
+    if (explicit === undefined) {
+      return value;
+    }
+    if (value !== undefined) {
+      const show = display ?? ((v: T) => String(v));
+      if (show(explicit) !== show(value)) {
+        conflicts.push(`\`${prop}\` (${show(explicit)}) overrides \`${setting}\` (${show(value)}) in the Cargo Lambda deploy settings`);
+      }
+    }
+    return explicit;
+  };
+
+  const role: IRole | undefined = config.role && !props.role
+    ? Role.fromRoleArn(scope, `${id}DeployRole`, config.role)
+    : undefined;
+  if (config.role && props.role) {
+    conflicts.push(`\`role\` overrides \`role\` (${config.role}) in the Cargo Lambda deploy settings`);
+  }
+
+  const layers: ILayerVersion[] | undefined = config.layers && !props.layers
+    ? config.layers.map((arn, i) => LayerVersion.fromLayerVersionArn(scope, `${id}DeployLayer${i}`, arn))
+    : undefined;
+  if (config.layers && props.layers) {
+    conflicts.push(`\`layers\` overrides \`layers\` (${config.layers.join(', ')}) in the Cargo Lambda deploy settings`);
+  }
+
+  const configEnvironment: { [key: string]: string } = {
+    ...(config.env_file ? readEnvFile(join(dirname(manifestPath), config.env_file)) : {}),
+    ...config.env,
+  };
+  for (const [key, value] of Object.entries(props.environment ?? {})) {
+    if (configEnvironment[key] !== undefined && configEnvironment[key] !== value) {
+      conflicts.push(`\`environment.${key}\` overrides \`env.${key}\` in the Cargo Lambda deploy settings`);
+    }
+  }
+  const environment = Object.keys(configEnvironment).length > 0
+    ? { ...configEnvironment, ...props.environment }
+    : props.environment;
+
+  return {
+    options: {
+      memorySize: pick('memorySize', 'memory', props.memorySize, config.memory),
+      timeout: pick('timeout', 'timeout', props.timeout, config.timeout !== undefined ? Duration.seconds(config.timeout) : undefined, d => `${d.toSeconds()}s`),
+      tracing: pick('tracing', 'tracing', props.tracing, tracingFromConfig(config.tracing)),
+      description: pick('description', 'description', props.description, config.description),
+      environment,
+      role: props.role ?? role,
+      layers: props.layers ?? layers,
+    },
+    conflicts,
+  };
+}
+
+function tracingFromConfig(tracing?: string): Tracing | undefined {
+  switch (tracing?.toLowerCase()) {
+    case undefined:
+      return undefined;
+    case 'active':
+      return Tracing.ACTIVE;
+    case 'passthrough':
+    case 'pass_through':
+      return Tracing.PASS_THROUGH;
+    default:
+      throw new Error(`invalid tracing mode \`${tracing}\` in the Cargo Lambda deploy settings, valid modes are: active, passthrough`);
+  }
+}
+
+function readEnvFile(path: string): { [key: string]: string } {
+  const env: { [key: string]: string } = {};
+  for (const line of readFileSync(path).toString('utf-8').split(/\r?\n/)) {
+    const trimmed = line.trim();
+    if (!trimmed || trimmed.startsWith('#') || !trimmed.includes('=')) {
+      continue;
+    }
+    const [key, ...value] = trimmed.replace(/^export\s+/, '').split('=');
+    env[key.trim()] = value.join('=').trim().replace(/^(['"])(.*)\1$/, '$2');
+  }
+  return env;
+}
+
+function readToml(path: string): any {
+  return existsSync(path) ? load(readFileSync(path).toString('utf-8')) : undefined;
+}
+
+/**
+ * Report the props that override the Cargo Lambda deploy settings as warnings.
+ */
+export function annotateDeployConflicts(scope: Construct, conflicts: string[]) {
+  for (const conflict of conflicts) {
+    Annotations.of(scope).addWarningV2('cargo-lambda-cdk:deploySettingsConflict', conflict);
+  }
+}
@@ -0,0 +1,226 @@
+import { createHash } from 'node:crypto';
+import { cpSync, existsSync, mkdirSync, renameSync, rmSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { Annotations, CfnResource, Stack, Stage } from 'aws-cdk-lib';
+import { Platform } from 'aws-cdk-lib/aws-ecr-assets';
+import {
+  Architecture,
+  Code,
+  CodeConfig,
+  DockerImageCode,
+  DockerImageFunction,
+  ResourceBindOptions,
+} from 'aws-cdk-lib/aws-lambda';
+import { Construct } from 'constructs';
+import { Bundling, BundlingProps } from './bundling';
+import { getCargoSource } from './cargo';
+import { annotateDeployConflicts, deployDefaults, resolveBuildConfig } from './config';
+import { RustFunctionProps } from './function';
+import { provenanceDescription, recordGitProvenance } from './provenance';
+import { BuildSetting, BundlingOptions, GitProvenance, RustRuntime } from './types';
+import { bundlingOptionsFromRustFunctionProps, bundlingOptionsWithContext } from './util';
+
+// the directory of the Docker context with the output of Cargo Lambda
+const CONTAINER_BUNDLE_DIR = 'bundle';
+
+/**
+ * Base images to build the container image of a RustContainerFunction from.
+ */
+export enum ContainerBaseImage {
+  /**
+   * The AWS Lambda base image for the `provided.al2023` runtime.
+   */
+  PROVIDED_AL2023 = 'provided.al2023',
+
+  /**
+   * The AWS Lambda base image for the `provided.al2` runtime.
+   */
+  PROVIDED_AL2 = 'provided.al2',
+
+  /**
+   * An empty image that only includes the files produced by Cargo Lambda.
+   * Your binary must be statically linked, i.e. built with a musl target.
+   */
+  SCRATCH = 'scratch',
+}
+
+/**
+ * Properties for a RustContainerFunction
+ */
+export interface RustContainerFunctionProps extends RustFunctionProps {
+  /**
+   * The base image for the container image that wraps the `bootstrap` binary.
+   *
+   * @default - the Lambda base image that matches the `runtime` option
+   */
+  readonly baseImage?: ContainerBaseImage;
+}
+
+/**
+ * A Rust Lambda function deployed as a container image
+ */
+export class RustContainerFunction extends DockerImageFunction {
+  /**
+   * The build settings resolved from the bundling options and the Cargo Lambda build configuration.
+   */
+  public readonly buildSettings: BuildSetting[];
+
+  /**
+   * The git commit that the function was built from, when the source code is in a git remote.
+   */
+  public readonly gitProvenance?: GitProvenance;
+
+  constructor(scope: Construct, resourceName: string, props?: RustContainerFunctionProps) {
+    if (props?.workspace) {
+      throw new Error('RustContainerFunction doesn\'t support the option `workspace`, use the option `manifestPath` instead');
+    }
+    if (props?.bundling?.prebuiltArtifact?.endsWith('.zip')) {
+      throw new Error('RustContainerFunction doesn\'t support zip files in the option `prebuiltArtifact`, use the directory with the `bootstrap` binary instead');
+    }
+
+    const { manifestPath, gitProvenance } = getCargoSource(props || {}, scope);
+
+    const buildConfig = resolveBuildConfig(
+      manifestPath,
+      bundlingOptionsWithContext(scope, bundlingOptionsFromRustFunctionProps(props)),
+    );
+    const bundling = buildConfig.bundling;
+    const baseImage = props?.baseImage
+      ?? (props?.runtime === RustRuntime.PROVIDED_AL2 ? ContainerBaseImage.PROVIDED_AL2 : ContainerBaseImage.PROVIDED_AL2023);
+
+    const deploy = props?.applyDeployConfig
+      ? deployDefaults(scope, resourceName, manifestPath, props ?? {})
+      : undefined;
+
+    const bundlingProps: BundlingProps = {
+      ...bundling,
+      manifestPath,
+      binaryName: props?.binaryName,
+      packageName: props?.packageName,
+      runtime: containerRuntime(baseImage),
+      containerImage: true,
+    };
+
+    const code: DockerImageCode = {
+      _bind: (architecture?: Architecture) => new RustContainerCode(bundlingProps, baseImage, architecture),
+    };
+
+    super(scope, resourceName, {
+      ...props,
+      ...deploy?.options,
+      description: gitProvenance && props?.gitProvenance?.description
+        ? provenanceDescription(gitProvenance, deploy?.options.description ?? props?.description)
+        : deploy?.options.description ?? props?.description,
+      architecture: bundling.architecture,
+      code,
+    });
+
+    this.buildSettings = buildConfig.settings;
+    this.gitProvenance = gitProvenance;
+    if (gitProvenance) {
+      recordGitProvenance(this, gitProvenance, props?.gitProvenance);
+    }
+    annotateDeployConflicts(this, deploy?.conflicts ?? []);
+
+    if (baseImage === ContainerBaseImage.SCRATCH && !buildTarget(bundling)?.endsWith('-musl')) {
+      Annotations.of(this).addWarningV2('cargo-lambda-cdk:scratchWithoutMusl',
+        'the base image `scratch` doesn\'t have the C library, so the binary must be statically linked. '
+        + 'Set the bundling option `target` to a musl target, i.e. `x86_64-unknown-linux-musl`');
+    }
+  }
+}
+
+/**
+ * Lambda code that builds the binary with Cargo Lambda and
+ * packages the output as a container image asset.
+ */
+class RustContainerCode extends Code {
+  private imageCode?: Code;
+
+  constructor(
+    private readonly props: BundlingProps,
+    private readonly baseImage: ContainerBaseImage,
+    private readonly architecture?: Architecture,
+  ) {
+    super();
+  }
+
+  public bind(scope: Construct): CodeConfig {
+    const staging = Bundling.stage(scope, 'RustBundle', this.props);
+    const dockerfile = containerDockerfile(this.baseImage);
+
+    // The Docker context has the Dockerfile and a copy of the bundle, so the staged bundle doesn't change
+    // after it was hashed. When bundling is skipped, the staged path is the source directory, so it's not copied.
+    const bundled = Stack.of(scope).bundlingRequired;
+    const contextDir = join(
+      Stage.of(scope)?.assetOutdir ?? tmpdir(),
+      `container.${createHash('sha256').update(staging.assetHash).update(dockerfile).digest('hex')}`,
+    );
+    if (!existsSync(contextDir)) {
+      const partialDir = `${contextDir}.partial-${process.pid}`;
+      rmSync(partialDir, { recursive: true, force: true });
+      mkdirSync(join(partialDir, CONTAINER_BUNDLE_DIR), { recursive: true });
+      if (bundled) {
+        cpSync(staging.absoluteStagedPath, join(partialDir, CONTAINER_BUNDLE_DIR), { recursive: true });
+      }
+      writeFileSync(join(partialDir, 'Dockerfile'), dockerfile);
+      renameSync(partialDir, contextDir);
+    }
+
+    this.imageCode = Code.fromAssetImage(contextDir, {
+      platform: this.architecture ? Platform.custom(this.architecture.dockerPlatform) : undefined,
+    });
+    return this.imageCode.bind(scope);
+  }
+
+  public bindToResource(resource: CfnResource, options?: ResourceBindOptions): void {
+    this.imageCode?.bindToResource(resource, options);
+  }
+}
+
+/**
+ * Generate the Dockerfile that copies the output of `cargo lambda build` into the base image.
+ */
+export function containerDockerfile(baseImage: ContainerBaseImage): string {
+  if (baseImage === ContainerBaseImage.SCRATCH) {
+    return [
+      'FROM scratch',
+      `COPY ${CONTAINER_BUNDLE_DIR}/ /var/task/`,
+      'WORKDIR /var/task',
+      'ENTRYPOINT [ "/var/task/bootstrap" ]',
+      '',
+    ].join('\n');
+  }
+
+  const tag = baseImage === ContainerBaseImage.PROVIDED_AL2 ? 'al2' : 'al2023';
+  return [
+    `FROM public.ecr.aws/lambda/provided:${tag}`,
+    `COPY ${CONTAINER_BUNDLE_DIR}/ \${LAMBDA_TASK_ROOT}/`,
+    'ENTRYPOINT [ "./bootstrap" ]',
+    '',
+  ].join('\n');
+}
+
+/**
+ * The target in the bundling options, or in the Cargo Lambda flags.
+ */
+function buildTarget(bundling: BundlingOptions): string | undefined {
+  const flags = bundling.cargoLambdaFlags ?? [];
+  const target = flags.indexOf('--target');
+  return bundling.target ?? (target >= 0 ? flags[target + 1] : undefined);
+}
+
+/**
+ * The Lambda runtime that matches the base image, to verify the GLIBC versions that the binary requires.
+ */
+function containerRuntime(baseImage: ContainerBaseImage): string | undefined {
+  switch (baseImage) {
+    case ContainerBaseImage.PROVIDED_AL2:
+      return RustRuntime.PROVIDED_AL2;
+    case ContainerBaseImage.PROVIDED_AL2023:
+      return RustRuntime.PROVIDED_AL2023;
+    default:
+      return undefined;
+  }
+}
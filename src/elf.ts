@@ -0,0 +1,106 @@
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { compareVersions } from './util';
+
+const ELF_MAGIC = Buffer.from([0x7f, 0x45, 0x4c, 0x46]);
+
+const EM_X86_64 = 0x3e;
+const EM_AARCH64 = 0xb7;
+
+/**
+ * The fields of an ELF header that identify the kind of binary.
+ */
+export interface ElfHeader {
+  readonly is64: boolean;
+  readonly littleEndian: boolean;
+  readonly machine: number;
+}
+
+/**
+ * Read the header of an ELF binary, or return undefined if the data is not an ELF binary.
+ */
+export function readElfHeader(data: Buffer): ElfHeader | undefined {
+  if (data.length < 20 || !data.subarray(0, 4).equals(ELF_MAGIC)) {
+    return undefined;
+  }
+
+  const littleEndian = data[5] === 1;
+  return {
+    is64: data[4] === 2,
+    littleEndian,
+    machine: littleEndian ? data.readUInt16LE(18) : data.readUInt16BE(18),
+  };
+}
+
+/**
+ * The Lambda architecture that an ELF machine type runs on.
+ */
+export function elfArchitecture(header: ElfHeader): Architecture | undefined {
+  switch (header.machine) {
+    case EM_X86_64:
+      return Architecture.X86_64;
+    case EM_AARCH64:
+      return Architecture.ARM_64;
+    default:
+      return undefined;
+  }
+}
+
+const SHT_GNU_VERNEED = 0x6ffffffe;
+
+/**
+ * The GLIBC versions that a 64-bit ELF binary requires, i.e. `['2.17', '2.28']`, sorted from oldest to newest.
+ * Statically linked binaries, like musl binaries, don't require any version.
+ */
+export function glibcVersions(data: Buffer): string[] {
+  const header = readElfHeader(data);
+  if (!header?.is64) {
+    return [];
+  }
+
+  const readU16 = (offset: number) => header.littleEndian ? data.readUInt16LE(offset) : data.readUInt16BE(offset);
+  const readU32 = (offset: number) => header.littleEndian ? data.readUInt32LE(offset) : data.readUInt32BE(offset);
+  const readU64 = (offset: number) => Number(header.littleEndian ? data.readBigUInt64LE(offset) : data.readBigUInt64BE(offset));
+
+  const sectionsOffset = readU64(0x28);
+  const sectionSize = readU16(0x3a);
+  const sectionCount = readU16(0x3c);
+  const section = (index: number) => {
+    const offset = sectionsOffset + index * sectionSize;
+    return {
+      type: readU32(offset + 0x04),
+      offset: readU64(offset + 0x18),
+      link: readU32(offset + 0x28),
+      info: readU32(offset + 0x2c),
+    };
+  };
+
+  const versions = new Set<string>();
+  for (let i = 0; i < sectionCount && sectionsOffset + (i + 1) * sectionSize <= data.length; i++) {
+    const verneed = section(i);
+    if (verneed.type !== SHT_GNU_VERNEED) {
+      continue;
+    }
+
+    const strings = section(verneed.link).offset;
+    let entry = verneed.offset;
+    for (let n = 0; n < verneed.info; n++) {
+      let aux = entry + readU32(entry + 8);
+      for (let a = 0; a < readU16(entry + 2); a++) {
+        const name = readString(data, strings + readU32(aux + 8));
+        const version = name.match(/^GLIBC_(\d+(\.\d+)*)$/)?.[1];
+        if (version) {
+          versions.add(version);
+        }
+        aux += readU32(aux + 12);
+      }
+      entry += readU32(entry + 12);
+    }
+  }
+
+  return [...versions].sort(compareVersions);
+}
+
+function readString(data: Buffer, offset: number): string {
+  const end = data.indexOf(0, offset);
+  return data.toString('utf-8', offset, end === -1 ? data.length : end);
+}
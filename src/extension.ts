@@ -1,12 +1,17 @@
 import {
+  Code,
   LayerVersion,
   LayerVersionOptions,
   Architecture,
 } from 'aws-cdk-lib/aws-lambda';
 import { Construct } from 'constructs';
 import { Bundling } from './bundling';
-import { getManifestPath } from './cargo';
-import { BundlingOptions } from './types';
+import { getCargoSource } from './cargo';
+import { RustCode } from './code';
+import { resolveBuildConfig } from './config';
+import { provenanceDescription, recordGitProvenance } from './provenance';
+import { BuildSetting, BundlingOptions, GitOptions, GitProvenance, GitProvenanceOptions } from './types';
+import { bundlingOptionsWithContext, prebuiltArtifactWithoutSources } from './util';
 
 /**
  * Properties for a RustExtension
@@ -24,18 +29,41 @@ export interface RustExtensionProps extends LayerVersionOptions {
    */
   readonly binaryName?: string;
 
+  /**
+   * The name of the workspace package that the binary belongs to.
+   *
+   * Use this option when several packages in the workspace declare a binary with the same name.
+   * The package is passed to `cargo lambda build` with the `--package` flag.
+   *
+   * @default - the package in `manifestPath`
+   */
+  readonly packageName?: string;
+
   /**
    * Path to a directory containing your Cargo.toml file, or to your Cargo.toml directly.
    *
    * This will accept a directory path containing a `Cargo.toml` file (i.e. `path/to/package`), or a filepath to your
    * `Cargo.toml` file (i.e. `path/to/Cargo.toml`). When the `gitRemote` option is provided,
-   * the `manifestPath` is relative to the root of the git repository.
+   * the `manifestPath` is relative to the root of the git repository. When the `sourceArchive`
+   * option is provided, it's relative to the root of the archive.
    *
    * @default - check the current directory for a `Cargo.toml` file, and throws
    *  an error if the file doesn't exist.
    */
   readonly manifestPath?: string;
 
+  /**
+   * Path to a `.tar.gz`, `.tgz`, `.tar`, `.zip` or `.crate` archive with the source code,
+   * i.e. the output of `cargo package`.
+   *
+   * The archive is extracted into a temporary directory named after the archive's content.
+   * When the archive contains a single directory, like `.crate` files, that directory
+   * is the root of the archive.
+   *
+   * @default - the source code is not in an archive
+   */
+  readonly sourceArchive?: string;
+
   /**
    * The git remote URL to clone (e.g `https://github.com/your_user/your_repo`).
    *
@@ -47,7 +75,7 @@ export interface RustExtensionProps extends LayerVersionOptions {
   /**
    * The git reference to checkout. This can be a branch, tag, or commit hash.
    *
-   * If this option is not provided, `git clone` will run with the flag `--depth 1`.
+   * Only the commit that the reference points to is fetched, without the history of the repository.
    *
    * @default - the default branch, i.e. HEAD.
    */
@@ -62,6 +90,22 @@ export interface RustExtensionProps extends LayerVersionOptions {
    */
   readonly gitForceClone?: boolean;
 
+  /**
+   * Authentication and checkout options for the `gitRemote` option.
+   *
+   * @default - clone with the credentials in the environment, without submodules
+   */
+  readonly gitOptions?: GitOptions;
+
+  /**
+   * Append the git remote and commit that the extension was built from to the description.
+   * The provenance is always recorded in the construct metadata, and in the
+   * `cargo-lambda-cdk.provenance.json` file in the cloud assembly. Layers don't support tags.
+   *
+   * @default - only the construct metadata and the provenance file
+   */
+  readonly gitProvenance?: GitProvenanceOptions;
+
   /**
    * The system architecture of the lambda extension
    *
@@ -74,25 +118,59 @@ export interface RustExtensionProps extends LayerVersionOptions {
  * A Lambda extension written in Rust
  */
 export class RustExtension extends LayerVersion {
+  /**
+   * The build settings resolved from the bundling options and the Cargo Lambda build configuration.
+   */
+  public readonly buildSettings: BuildSetting[];
+
+  /**
+   * The git commit that the extension was built from, when the source code is in a git remote.
+   */
+  public readonly gitProvenance?: GitProvenance;
+
   constructor(
     scope: Construct,
     resourceName: string,
     props?: RustExtensionProps,
   ) {
-    const manifestPath = getManifestPath(props || {});
-    const bundling = props?.bundling ?? {};
+    const options = bundlingOptionsWithContext(scope, props?.bundling ?? {});
     const architecture = props?.architecture ?? Architecture.X86_64;
 
-    super(scope, resourceName, {
-      ...props,
-      compatibleArchitectures: [architecture],
-      code: Bundling.bundle({
-        ...bundling,
-        manifestPath,
+    let code: Code;
+    let buildSettings: BuildSetting[] = [];
+    let gitProvenance: GitProvenance | undefined;
+    const prebuiltArtifact = prebuiltArtifactWithoutSources(props, options);
+    if (prebuiltArtifact) {
+      // the artifact is deployed without reading the Cargo sources
+      code = RustCode.fromCargoLambdaOutput(prebuiltArtifact, { architecture, binaryName: props?.binaryName, lambdaExtension: true });
+    } else {
+      const source = getCargoSource(props || {}, scope);
+      gitProvenance = source.gitProvenance;
+      const buildConfig = resolveBuildConfig(source.manifestPath, options);
+      buildSettings = buildConfig.settings;
+      code = Bundling.bundle({
+        ...buildConfig.bundling,
+        manifestPath: source.manifestPath,
         binaryName: props?.binaryName,
+        packageName: props?.packageName,
         lambdaExtension: true,
         architecture,
-      }),
+      });
+    }
+
+    super(scope, resourceName, {
+      ...props,
+      description: gitProvenance && props?.gitProvenance?.description
+        ? provenanceDescription(gitProvenance, props?.description)
+        : props?.description,
+      compatibleArchitectures: [architecture],
+      code,
     });
+
+    this.buildSettings = buildSettings;
+    this.gitProvenance = gitProvenance;
+    if (gitProvenance) {
+      recordGitProvenance(this, gitProvenance);
+    }
   }
 }
@@ -0,0 +1,97 @@
+import { spawnSync } from 'node:child_process';
+import { createHash } from 'node:crypto';
+import { readdirSync, readFileSync, realpathSync, statSync } from 'node:fs';
+import { join, posix, relative, resolve, sep } from 'node:path';
+import { installedCargoLambdaVersion } from './probe';
+
+/**
+ * The directories in the workspace root that are never part of the sources: dependencies
+ * and outputs of a CDK app in the same directory, and version control metadata.
+ * The directories with the same names deeper in the workspace are sources.
+ */
+const IGNORED_WORKSPACE_DIRS = ['.git', 'node_modules', 'cdk.out'];
+
+/**
+ * The environment variables that change the output of Cargo.
+ */
+const BUILD_ENVIRONMENT = /^(RUSTFLAGS|CARGO_ENCODED_RUSTFLAGS|CARGO_BUILD_.*|CARGO_PROFILE_.*|CARGO_TARGET_.*_LINKER)$/;
+
+/**
+ * The paths that are not part of the sources of a workspace: its target directory,
+ * and the directories in the workspace root that are never sources.
+ */
+export function ignoredPaths(workspaceRoot: string, targetDir: string): string[] {
+  return [targetDir, ...IGNORED_WORKSPACE_DIRS.map(dir => join(workspaceRoot, dir))];
+}
+
+/**
+ * Calculate the fingerprint of a Rust build from the sources that it reads, and the build
+ * settings: the command, the environment and the identity of the toolchain.
+ *
+ * The paths are hashed relative to the project root, so the fingerprint doesn't change
+ * when the project moves to a different directory.
+ */
+export function buildFingerprint(projectRoot: string, sourcePaths: string[], settings: unknown, ignored: string[] = []): string {
+  const hash = createHash('sha256');
+  hash.update(JSON.stringify(settings));
+
+  const ignoredDirs = new Set(ignored.map(path => resolve(path)));
+  const files = sourcePaths
+    .flatMap(path => sourceFiles(resolve(path), ignoredDirs, new Set()))
+    .map(file => ({ file, name: relative(projectRoot, file).split(sep).join(posix.sep) }))
+    .sort((a, b) => a.name.localeCompare(b.name));
+
+  for (const { file, name } of files) {
+    hash.update(`\0${name}\0`);
+    hash.update(readFileSync(file));
+  }
+  return hash.digest('hex');
+}
+
+function sourceFiles(path: string, ignored: Set<string>, visited: Set<string>): string[] {
+  // the links are followed like Cargo does, links to missing files are not sources
+  const stat = statSync(path, { throwIfNoEntry: false });
+  if (!stat || ignored.has(path)) {
+    return [];
+  }
+  if (!stat.isDirectory()) {
+    return stat.isFile() ? [path] : [];
+  }
+
+  // a link to a parent directory would be visited forever
+  const realPath = realpathSync(path);
+  if (visited.has(realPath)) {
+    return [];
+  }
+  visited.add(realPath);
+  const files = readdirSync(path).flatMap(name => sourceFiles(join(path, name), ignored, visited));
+  visited.delete(realPath);
+  return files;
+}
+
+/**
+ * The identity of the local toolchain: the versions of `rustc` and Cargo Lambda, the compiler,
+ * and the environment variables that change the output of Cargo.
+ */
+export function localToolchainIdentity(compiler?: string): unknown {
+  const rustc = spawnSync('rustc', ['-vV']);
+  return {
+    rustc: rustc.status === 0 ? rustc.stdout.toString().trim() : undefined,
+    cargoLambda: installedCargoLambdaVersion(),
+    compiler,
+    environment: Object.fromEntries(Object.entries(process.env)
+      .filter(([key]) => BUILD_ENVIRONMENT.test(key))
+      .sort(([a], [b]) => a.localeCompare(b))),
+  };
+}
+
+/**
+ * The identity of a Docker image: the configured reference, with its digest when the reference pins one.
+ * The image is not inspected, so the identity is the same whether the image was pulled or not.
+ */
+export function dockerImageIdentity(image: string): unknown {
+  return {
+    image,
+    digest: /@(sha256:[0-9a-f]{64})$/.exec(image)?.[1],
+  };
+}
@@ -1,9 +1,21 @@
+import { Annotations } from 'aws-cdk-lib';
 import { Function, FunctionOptions, Runtime } from 'aws-cdk-lib/aws-lambda';
 import { Construct } from 'constructs';
 import { Bundling } from './bundling';
-import { getManifestPath } from './cargo';
-import { BundlingOptions } from './types';
-import { bundlingOptionsFromRustFunctionProps } from './util';
+import { getCargoSource } from './cargo';
+import { RustCode } from './code';
+import { annotateDeployConflicts, deployDefaults, DeployDefaults, resolveBuildConfig } from './config';
+import { provenanceDescription, recordGitProvenance } from './provenance';
+import { BuildSetting, BundlingOptions, GitOptions, GitProvenance, GitProvenanceOptions, RustRuntime } from './types';
+import {
+  architectureFromWorkspace,
+  binaryNameFromWorkspaceProps,
+  bundlingOptionsFromRustFunctionProps,
+  bundlingOptionsWithContext,
+  prebuiltArtifactWithoutSources,
+  runtimeFromWorkspace,
+} from './util';
+import { RustWorkspace } from './workspace';
 
 export { cargoLambdaVersion } from './bundling';
 
@@ -13,7 +25,12 @@ export { cargoLambdaVersion } from './bundling';
 export interface RustFunctionProps extends FunctionOptions {
   /**
    * The Lambda runtime to deploy this function.
+   *
    * `provided.al2023` is the default runtime when this option is not provided.
+   * The values of `RustRuntime` can also be used.
+   *
+   * The runtime also selects the GLIBC version that the binary is built for,
+   * see the `target` bundling option.
    */
   readonly runtime?: 'provided.al2023' | 'provided.al2';
 
@@ -29,18 +46,63 @@ export interface RustFunctionProps extends FunctionOptions {
    */
   readonly binaryName?: string;
 
+  /**
+   * The name of the workspace package that the binary belongs to.
+   *
+   * Use this option when several packages in the workspace declare a binary with the same name.
+   * The package is passed to `cargo lambda build` with the `--package` flag.
+   *
+   * @default - the package in `manifestPath`
+   */
+  readonly packageName?: string;
+
+  /**
+   * Cargo features to activate, the same as the bundling option `features`.
+   *
+   * @default - the features in the bundling options
+   */
+  readonly features?: string[];
+
+  /**
+   * Do not activate the `default` feature, the same as the bundling option `noDefaultFeatures`.
+   *
+   * @default - the option in the bundling options
+   */
+  readonly noDefaultFeatures?: boolean;
+
+  /**
+   * Activate all available features, the same as the bundling option `allFeatures`.
+   * It cannot be used with the option `features`.
+   *
+   * @default - the option in the bundling options
+   */
+  readonly allFeatures?: boolean;
+
   /**
    * Path to a directory containing your Cargo.toml file, or to your Cargo.toml directly.
    *
    * This will accept a directory path containing a `Cargo.toml` file (i.e. `path/to/package`), or a filepath to your
    * `Cargo.toml` file (i.e. `path/to/Cargo.toml`). When the `gitRemote` option is provided,
-   * the `manifestPath` is relative to the root of the git repository.
+   * the `manifestPath` is relative to the root of the git repository. When the `sourceArchive`
+   * option is provided, it's relative to the root of the archive.
    *
    * @default - check the current directory for a `Cargo.toml` file, and throws
    *  an error if the file doesn't exist.
    */
   readonly manifestPath?: string;
 
+  /**
+   * Path to a `.tar.gz`, `.tgz`, `.tar`, `.zip` or `.crate` archive with the source code,
+   * i.e. the output of `cargo package`.
+   *
+   * The archive is extracted into a temporary directory named after the archive's content.
+   * When the archive contains a single directory, like `.crate` files, that directory
+   * is the root of the archive.
+   *
+   * @default - the source code is not in an archive
+   */
+  readonly sourceArchive?: string;
+
   /**
    * The git remote URL to clone (e.g `https://github.com/your_user/your_repo`).
    *
@@ -52,7 +114,7 @@ export interface RustFunctionProps extends FunctionOptions {
   /**
    * The git reference to checkout. This can be a branch, tag, or commit hash.
    *
-   * If this option is not provided, `git clone` will run with the flag `--depth 1`.
+   * Only the commit that the reference points to is fetched, without the history of the repository.
    *
    * @default - the default branch, i.e. HEAD.
    */
@@ -66,28 +128,135 @@ export interface RustFunctionProps extends FunctionOptions {
    * temporary directory.
    */
   readonly gitForceClone?: boolean;
+
+  /**
+   * Authentication and checkout options for the `gitRemote` option.
+   *
+   * @default - clone with the credentials in the environment, without submodules
+   */
+  readonly gitOptions?: GitOptions;
+
+  /**
+   * Record the git remote, reference and commit that the function was built from
+   * as tags or in the description. The provenance is always recorded in the construct
+   * metadata, and in the `cargo-lambda-cdk.provenance.json` file in the cloud assembly.
+   *
+   * @default - only the construct metadata and the provenance file
+   */
+  readonly gitProvenance?: GitProvenanceOptions;
+
+  /**
+   * A workspace that builds the binary for this function together with other binaries.
+   *
+   * When this option is provided, `binaryName` is required, and the source and bundling
+   * options are taken from the workspace.
+   *
+   * @default - the function is built with its own `cargo lambda build` command.
+   */
+  readonly workspace?: RustWorkspace;
+
+  /**
+   * Apply the deploy settings from `[package.metadata.lambda.deploy]` in Cargo.toml,
+   * or from the `[deploy]` section in CargoLambda.toml, as defaults for this function.
+   *
+   * The memory, timeout, tracing, description, environment, role and layers settings are applied.
+   * The props set in this construct always take precedence, and each conflict is reported as a warning.
+   * The settings are not applied to the functions built by a workspace or from a prebuilt artifact
+   * without the Cargo sources.
+   *
+   * @default false
+   */
+  readonly applyDeployConfig?: boolean;
 }
 
 /**
  * A Rust Lambda function
  */
 export class RustFunction extends Function {
+  /**
+   * The build settings resolved from the bundling options and the Cargo Lambda build configuration.
+   */
+  public readonly buildSettings: BuildSetting[];
+
+  /**
+   * The git commit that the function was built from, when the source code is in a git remote.
+   */
+  public readonly gitProvenance?: GitProvenance;
+
   constructor(scope: Construct, resourceName: string, props?: RustFunctionProps) {
-    const manifestPath = getManifestPath(props || {});
+    const runtime = new Runtime(props?.workspace
+      ? runtimeFromWorkspace(props.workspace, props)
+      : props?.runtime || RustRuntime.PROVIDED_AL2023);
 
-    const runtime = new Runtime(props?.runtime || 'provided.al2023');
-    const bundling = bundlingOptionsFromRustFunctionProps(props);
+    let architecture;
+    let code;
+    let deploy: DeployDefaults | undefined;
+    let buildSettings: BuildSetting[];
+    let gitProvenance: GitProvenance | undefined;
+    if (props?.workspace) {
+      if (props.applyDeployConfig) {
+        Annotations.of(scope).addWarningV2('cargo-lambda-cdk:deploySettingsIgnored',
+          'the deploy settings are not applied to the functions built by a workspace, set the function props instead');
+      }
+      if (props.features || props.noDefaultFeatures !== undefined || props.allFeatures !== undefined) {
+        throw new Error('the features of a function built by a workspace are the features of the workspace, set the bundling options of the workspace instead');
+      }
+      architecture = architectureFromWorkspace(props.workspace, props);
+      code = props.workspace.binaryCode(binaryNameFromWorkspaceProps(props));
+      buildSettings = props.workspace.buildSettings;
+      gitProvenance = props.workspace.gitProvenance;
+    } else {
+      const options = bundlingOptionsWithContext(scope, bundlingOptionsFromRustFunctionProps(props));
+      const prebuiltArtifact = prebuiltArtifactWithoutSources(props, options);
+      if (prebuiltArtifact) {
+        // the artifact is deployed without reading the Cargo sources
+        if (props?.applyDeployConfig) {
+          Annotations.of(scope).addWarningV2('cargo-lambda-cdk:deploySettingsIgnored',
+            'the deploy settings are not applied to prebuilt artifacts without the Cargo sources, set the function props instead');
+        }
+        architecture = options.architecture;
+        code = RustCode.fromCargoLambdaOutput(prebuiltArtifact, { architecture, binaryName: props?.binaryName });
+        buildSettings = [];
+      } else {
+        const source = getCargoSource(props || {}, scope);
+        const manifestPath = source.manifestPath;
+        gitProvenance = source.gitProvenance;
+        const buildConfig = resolveBuildConfig(manifestPath, options);
+        const bundling = buildConfig.bundling;
+        buildSettings = buildConfig.settings;
+
+        if (props?.applyDeployConfig) {
+          deploy = deployDefaults(scope, resourceName, manifestPath, props ?? {});
+        }
+
+        architecture = bundling.architecture;
+        code = Bundling.bundle({
+          ...bundling,
+          manifestPath,
+          binaryName: props?.binaryName,
+          packageName: props?.packageName,
+          runtime: runtime.name,
+        });
+      }
+    }
 
     super(scope, resourceName, {
       ...props,
+      ...deploy?.options,
+      description: gitProvenance && props?.gitProvenance?.description
+        ? provenanceDescription(gitProvenance, deploy?.options.description ?? props?.description)
+        : deploy?.options.description ?? props?.description,
       runtime,
-      architecture: bundling.architecture,
-      code: Bundling.bundle({
-        ...bundling,
-        manifestPath,
-        binaryName: props?.binaryName,
-      }),
+      architecture,
+      code,
       handler: 'bootstrap',
     });
+
+    this.buildSettings = buildSettings;
+    this.gitProvenance = gitProvenance;
+    if (gitProvenance) {
+      recordGitProvenance(this, gitProvenance, props?.gitProvenance);
+    }
+    annotateDeployConflicts(this, deploy?.conflicts ?? []);
   }
 }
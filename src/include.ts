@@ -0,0 +1,141 @@
+import { existsSync, readdirSync, realpathSync, statSync } from 'node:fs';
+import { isAbsolute, join, posix, relative, sep } from 'node:path';
+import { IncludeFile } from './types';
+
+/**
+ * A file or directory to copy into the bundling output.
+ */
+export interface ResolvedInclude {
+  /**
+   * Absolute path to the file or directory in the host.
+   */
+  readonly source: string;
+
+  /**
+   * Path relative to the root of the bundling output, with `/` separators.
+   */
+  readonly destination: string;
+
+  /**
+   * Whether the source is a directory.
+   */
+  readonly directory: boolean;
+}
+
+/**
+ * Expand the globs in the include options into the list of files and directories to copy.
+ *
+ * Sources are relative to the package directory. Without a destination, each file keeps
+ * its path relative to the package directory. With a destination, a single file or directory
+ * is copied to that path, and the matches of a glob are copied inside that directory.
+ *
+ * With a build root, the sources must be inside it, the build root is the only directory
+ * that is mounted in Docker builds and hashed.
+ */
+export function resolveIncludes(packageDir: string, includes: IncludeFile[], buildRoot?: string): ResolvedInclude[] {
+  const resolved: ResolvedInclude[] = [];
+
+  for (const include of includes) {
+    if (isAbsolute(include.source)) {
+      throw new Error(`the include source \`${include.source}\` must be relative to the directory with the Cargo.toml file`);
+    }
+
+    const pattern = include.source.split(sep).join(posix.sep);
+    const segments = pattern.split(posix.sep);
+    const globIndex = segments.findIndex(isGlob);
+
+    if (globIndex === -1) {
+      const source = join(packageDir, ...segments);
+      if (!existsSync(source)) {
+        throw new Error(`the include source \`${include.source}\` doesn't exist`);
+      }
+      resolved.push({
+        source,
+        destination: include.destination ?? toPosix(relative(packageDir, source)),
+        directory: statSync(source).isDirectory(),
+      });
+      continue;
+    }
+
+    const base = join(packageDir, ...segments.slice(0, globIndex));
+    const matches = matchGlob(base, segments.slice(globIndex));
+    if (matches.length === 0) {
+      throw new Error(`the include source \`${include.source}\` doesn't match any file`);
+    }
+
+    for (const source of matches) {
+      resolved.push({
+        source,
+        destination: include.destination !== undefined
+          ? posix.join(include.destination, toPosix(relative(base, source)))
+          : toPosix(relative(packageDir, source)),
+        directory: statSync(source).isDirectory(),
+      });
+    }
+  }
+
+  for (const include of resolved) {
+    if (buildRoot && !isInside(buildRoot, include.source)) {
+      throw new Error(`the include source \`${include.source}\` must be inside the directory \`${buildRoot}\` of the build`);
+    }
+    const destination = posix.normalize(include.destination);
+    if (destination === '..' || destination.startsWith(`..${posix.sep}`) || posix.isAbsolute(destination)) {
+      throw new Error(`the include destination \`${include.destination}\` must be inside the bundling output`);
+    }
+  }
+
+  return resolved;
+}
+
+// the links are resolved, so a link inside the directory can't include a file outside it
+function isInside(dir: string, path: string): boolean {
+  const rel = relative(realpathSync.native(dir), realpathSync.native(path));
+  return rel !== '..' && !rel.startsWith(`..${sep}`) && !isAbsolute(rel);
+}
+
+function matchGlob(dir: string, segments: string[]): string[] {
+  if (segments.length === 0) {
+    return [dir];
+  }
+  if (!existsSync(dir) || !statSync(dir).isDirectory()) {
+    return [];
+  }
+
+  const [segment, ...rest] = segments;
+  const entries = readdirSync(dir);
+
+  if (segment === '**') {
+    // `**` matches zero or more directories, without hidden directories and the target directory
+    return [
+      ...matchGlob(dir, rest),
+      ...entries
+        .filter(entry => entry !== 'target' && !entry.startsWith('.') && statSync(join(dir, entry)).isDirectory())
+        .flatMap(entry => matchGlob(join(dir, entry), segments)),
+    ].filter((match, index, all) => all.indexOf(match) === index);
+  }
+
+  // wildcards only match dotfiles when the pattern starts with a dot, i.e. `.*`,
+  // and they only match the target directory when it's named in the pattern
+  const matcher = globSegmentRegex(segment);
+  return entries
+    .filter(entry => segment.startsWith('.') || !entry.startsWith('.'))
+    .filter(entry => !isGlob(segment) || entry !== 'target')
+    .filter(entry => matcher.test(entry))
+    .flatMap(entry => matchGlob(join(dir, entry), rest));
+}
+
+function globSegmentRegex(segment: string): RegExp {
+  const escaped = segment
+    .replace(/[.+^${}()|[\]\\]/g, '\\$&')
+    .replace(/\*/g, '[^/]*')
+    .replace(/\?/g, '[^/]');
+  return new RegExp(`^${escaped}$`);
+}
+
+function isGlob(segment: string): boolean {
+  return segment.includes('*') || segment.includes('?');
+}
+
+function toPosix(path: string): string {
+  return path.split(sep).join(posix.sep);
+}
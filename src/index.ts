@@ -1,3 +1,9 @@
+export { BuildCache, BuildCacheEntry } from './buildcache';
+export * from './code';
+export * from './container';
 export * from './extension';
 export * from './function';
+export * from './probe';
 export * from './types';
+export { DockerCache, DockerCachePruneOptions } from './volumes';
+export * from './workspace';
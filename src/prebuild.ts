@@ -0,0 +1,244 @@
+import { spawnSync } from 'node:child_process';
+import { cpSync, existsSync, mkdirSync, rmSync, writeFileSync } from 'node:fs';
+import { cpus } from 'node:os';
+import { join } from 'node:path';
+import { Aspects, IAspect, Stage } from 'aws-cdk-lib';
+import { Construct, IConstruct } from 'constructs';
+import { BuildJob, BuildResult } from './runner';
+
+/**
+ * The context key that enables the prebuild phase, with the maximum number of builds
+ * that run at the same time, or `true` to run as many builds as CPUs.
+ */
+export const PARALLEL_BUILDS_CONTEXT = 'cargo-lambda-cdk:parallelBuilds';
+
+// the file that marks a staged asset whose build runs in the prebuild phase
+const PENDING_MARKER = '.cargo-lambda-cdk-pending';
+
+interface PendingAsset {
+  readonly stagedPath: string;
+  readonly finish: () => void;
+}
+
+interface PendingBuild {
+  readonly fingerprint: string;
+  readonly job: BuildJob;
+  readonly assets: PendingAsset[];
+}
+
+/**
+ * Run the builds of the prebuild phase, and return their results, or the error of the phase.
+ */
+export type PrebuildRunner = (jobs: BuildJob[], concurrency: number) => BuildResult[] | string;
+
+const queues = new WeakMap<IConstruct, PrebuildQueue>();
+const stages = new WeakSet<Stage>();
+let prebuildRunner: PrebuildRunner = runPrebuildPhase;
+
+/**
+ * The builds of an app that are deferred to the prebuild phase, which runs them in parallel
+ * when a stage is synthesized, before its cloud assembly is written.
+ *
+ * The builds are only deferred when the asset hash is the build fingerprint, so the assets
+ * can be staged before the binaries exist.
+ */
+export class PrebuildQueue {
+  /**
+   * The prebuild queue of the app that contains the scope, or undefined when
+   * the prebuild phase is not enabled in the CDK context.
+   *
+   * The assets of nested stages are built with the assets of the app, so the identical
+   * builds of different stages only run once.
+   */
+  public static of(scope: Construct): PrebuildQueue | undefined {
+    const concurrency = parallelBuilds(scope.node.tryGetContext(PARALLEL_BUILDS_CONTEXT));
+    if (!concurrency) {
+      return undefined;
+    }
+
+    const owner = scope.node.root;
+    let queue = queues.get(owner);
+    if (!queue) {
+      queue = new PrebuildQueue(concurrency);
+      queues.set(owner, queue);
+    }
+
+    // the aspects run even when the validation is skipped, and a nested stage
+    // is synthesized before the app, so each stage runs the pending builds
+    const stage = Stage.of(scope);
+    if (stage && !stages.has(stage)) {
+      stages.add(stage);
+      Aspects.of(stage).add(new PrebuildPhase(stage, queue));
+    }
+    return queue;
+  }
+
+  /**
+   * Replace the process that runs the builds, or restore it when the runner is undefined, for tests.
+   */
+  public static useRunner(runner?: PrebuildRunner) {
+    prebuildRunner = runner ?? runPrebuildPhase;
+  }
+
+  /**
+   * An empty directory to stage in place of an asset that is copied from a pending asset.
+   */
+  public static placeholder(outdir: string): string {
+    const placeholder = join(outdir, 'cargo-lambda-cdk-pending');
+    mkdirSync(placeholder, { recursive: true });
+    writeFileSync(join(placeholder, PENDING_MARKER), '');
+    return placeholder;
+  }
+
+  /**
+   * Whether the staged asset is waiting for its build.
+   */
+  public static isPending(stagedPath: string): boolean {
+    return existsSync(join(stagedPath, PENDING_MARKER));
+  }
+
+  private readonly builds: PendingBuild[] = [];
+  private readonly copies: Array<{ readonly from: string; readonly stagedPath: string; readonly copy: () => void }> = [];
+  private readonly stagedPaths = new Set<string>();
+
+  private constructor(private readonly concurrency: number) {}
+
+  /**
+   * Mark the bundle as pending, CDK stages it like any other bundle, and the build
+   * writes the binary in the staged asset later.
+   */
+  public defer(outputDir: string) {
+    writeFileSync(join(outputDir, PENDING_MARKER), '');
+  }
+
+  /**
+   * Add the build of a staged asset, `finish` runs once the build writes the asset.
+   *
+   * The builds with the same fingerprint only run once, in the first asset,
+   * and the output is copied to the other assets.
+   */
+  public add(fingerprint: string, job: BuildJob, stagedPath: string, finish: () => void) {
+    this.stagedPaths.add(stagedPath);
+    const pending = this.builds.find(build => build.fingerprint === fingerprint);
+    if (pending) {
+      pending.assets.push({ stagedPath, finish });
+    } else {
+      this.builds.push({ fingerprint, job, assets: [{ stagedPath, finish }] });
+    }
+  }
+
+  /**
+   * Copy the output of a pending asset in another staged asset once its build finishes,
+   * `copy` runs after the build writes the asset.
+   */
+  public addCopy(from: string, stagedPath: string, copy: () => void) {
+    this.stagedPaths.add(stagedPath);
+    this.copies.push({ from, stagedPath, copy });
+  }
+
+  /**
+   * Run the pending builds, and return the errors of the builds that failed,
+   * and of the assets that are still waiting for a build.
+   */
+  public run(): string[] {
+    const errors = this.build();
+    for (const copy of this.copies.splice(0)) {
+      if (!PrebuildQueue.isPending(copy.from)) {
+        copy.copy();
+        rmSync(join(copy.stagedPath, PENDING_MARKER), { force: true });
+      }
+    }
+
+    const stagedPaths = [...this.stagedPaths];
+    this.stagedPaths.clear();
+    if (errors.length === 0) {
+      for (const stagedPath of stagedPaths.filter(path => PrebuildQueue.isPending(path))) {
+        errors.push(`the asset \`${stagedPath}\` is still waiting for its Rust build`);
+      }
+    }
+    return errors;
+  }
+
+  private build(): string[] {
+    const builds = this.builds.splice(0);
+    if (builds.length === 0) {
+      return [];
+    }
+
+    process.stderr.write(`Building ${builds.length} Rust assets, ${this.concurrency} at a time\n`);
+    const results = prebuildRunner(builds.map(build => build.job), this.concurrency);
+    if (!Array.isArray(results)) {
+      return [results];
+    }
+
+    const errors: string[] = [];
+    for (const build of builds) {
+      const result = results.find(r => r.id === build.job.id);
+      if (result?.status !== 0) {
+        const reason = result?.error ?? (result ? `exit status ${result.status}` : 'the build did not finish');
+        errors.push(`the Rust build of \`${build.job.id}\` failed: ${reason}`);
+        continue;
+      }
+
+      const [built, ...copies] = build.assets.map(asset => asset.stagedPath);
+      rmSync(join(built, PENDING_MARKER), { force: true });
+      for (const stagedPath of copies.filter(path => path !== built)) {
+        cpSync(built, stagedPath, { recursive: true });
+        rmSync(join(stagedPath, PENDING_MARKER), { force: true });
+      }
+      build.assets.forEach(asset => asset.finish());
+    }
+    return errors;
+  }
+}
+
+/**
+ * Run the pending builds when the stage is synthesized, the synthesis fails when a build fails.
+ */
+class PrebuildPhase implements IAspect {
+  constructor(private readonly stage: Stage, private readonly queue: PrebuildQueue) {}
+
+  public visit(node: IConstruct) {
+    if (node !== this.stage) {
+      return;
+    }
+
+    const errors = this.queue.run();
+    if (errors.length > 0) {
+      throw new Error(`the Rust prebuild phase failed:\n  ${errors.join('\n  ')}`);
+    }
+  }
+}
+
+function parallelBuilds(value: unknown): number | undefined {
+  if (value === undefined || value === false || value === 'false') {
+    return undefined;
+  }
+  if (value === true || value === 'true') {
+    return cpus().length;
+  }
+
+  const concurrency = Number(value);
+  if (!Number.isInteger(concurrency) || concurrency < 1) {
+    throw new Error(`the context key \`${PARALLEL_BUILDS_CONTEXT}\` must be \`true\` or the maximum number of parallel builds, got \`${value}\``);
+  }
+  return concurrency;
+}
+
+/**
+ * Run the builds in a different Node.js process, the construct tree is synthesized synchronously,
+ * and only a different process can wait for several builds at the same time.
+ */
+function runPrebuildPhase(jobs: BuildJob[], concurrency: number): BuildResult[] | string {
+  const runner = join(__dirname, 'runner');
+  const proc = spawnSync(process.execPath, ['-e', `require(${JSON.stringify(runner)}).main()`], {
+    input: JSON.stringify({ jobs, concurrency }),
+    stdio: ['pipe', 'pipe', 'inherit'],
+    maxBuffer: 64 * 1024 * 1024,
+  });
+
+  if (proc.error || proc.status !== 0) {
+    return `the Rust prebuild phase failed: ${proc.error ?? `exit status ${proc.status}`}`;
+  }
+  return JSON.parse(proc.stdout.toString());
+}
@@ -0,0 +1,308 @@
+import { spawnSync } from 'child_process';
+import { platform } from 'node:os';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { runtimeTarget } from './target';
+import { Compiler } from './types';
+import { compareVersions } from './util';
+
+/**
+ * The oldest Cargo Lambda version that supports all the flags and settings used by the constructs.
+ */
+const MIN_CARGO_LAMBDA_VERSION = '1.0.0';
+
+const CARGO_LAMBDA_NOT_INSTALLED = 'Cargo Lambda is not installed, see https://www.cargo-lambda.info/guide/installation.html';
+
+/**
+ * Options to probe the bundling capabilities of the host
+ */
+export interface BundlingProbeOptions {
+  /**
+   * The architecture to build for.
+   *
+   * @default Architecture.X86_64
+   */
+  readonly architecture?: Architecture;
+
+  /**
+   * The compiler that Cargo Lambda uses.
+   *
+   * @default - the default Cargo Lambda compiler, `cargo-zigbuild`
+   */
+  readonly compiler?: Compiler;
+
+  /**
+   * The target triple to build for, it takes precedence over the architecture.
+   *
+   * @default - the glibc target for the architecture
+   */
+  readonly target?: string;
+
+  /**
+   * The Lambda runtime that runs the binary. Plain Cargo is not used automatically for
+   * runtimes with an older GLIBC than Cargo Lambda links against by default.
+   *
+   * @default - any runtime
+   */
+  readonly runtime?: string;
+}
+
+/**
+ * What the host can use to build a Rust binary for a target.
+ */
+export interface BundlingCapabilities {
+  /**
+   * The version of Cargo Lambda installed locally.
+   *
+   * @default - Cargo Lambda is not installed
+   */
+  readonly cargoLambdaVersion?: string;
+
+  /**
+   * The compiler that Cargo Lambda uses for local builds.
+   */
+  readonly localCompiler: Compiler;
+
+  /**
+   * Whether the tools that the local compiler needs are installed.
+   */
+  readonly localCompilerInstalled: boolean;
+
+  /**
+   * The Rust target triple that the binary is built for.
+   */
+  readonly rustTarget: string;
+
+  /**
+   * Whether the Rust target is installed with rustup.
+   *
+   * @default - unknown, rustup is not installed or the compiler doesn't need the target locally
+   */
+  readonly rustTargetInstalled?: boolean;
+
+  /**
+   * Whether the Docker daemon is reachable with the `docker` command, or the command in `CDK_DOCKER`.
+   */
+  readonly dockerAvailable: boolean;
+
+  /**
+   * Whether the binary can be built locally.
+   */
+  readonly canBuildLocally: boolean;
+
+  /**
+   * The reasons why the binary can't be built locally.
+   */
+  readonly localProblems: string[];
+
+  /**
+   * The reasons why the binary can't be built in a Docker container.
+   */
+  readonly dockerProblems: string[];
+}
+
+/**
+ * Detect the tools that are available to build Rust binaries in this host.
+ */
+export class BundlingProbe {
+  /**
+   * Probe the local toolchain and Docker for a target.
+   *
+   * The results are cached for the lifetime of the process.
+   */
+  public static run(options: BundlingProbeOptions = {}): BundlingCapabilities {
+    const key = [options.architecture?.name, options.compiler, options.target, options.runtime].join(':');
+    let capabilities = BundlingProbe.cache.get(key);
+    if (!capabilities) {
+      capabilities = probe(options);
+      BundlingProbe.cache.set(key, capabilities);
+    }
+    return capabilities;
+  }
+
+  /**
+   * Forget the results of previous probes.
+   */
+  public static clearCache(): void {
+    BundlingProbe.cache.clear();
+    imageCommands.clear();
+  }
+
+  private static cache = new Map<string, BundlingCapabilities>();
+}
+
+function probe(options: BundlingProbeOptions): BundlingCapabilities {
+  const localProblems: string[] = [];
+  const dockerProblems: string[] = [];
+
+  const version = installedCargoLambdaVersion();
+  if (version === undefined) {
+    localProblems.push(CARGO_LAMBDA_NOT_INSTALLED);
+  } else if (compareVersions(version, MIN_CARGO_LAMBDA_VERSION) < 0) {
+    localProblems.push(`Cargo Lambda ${version} is older than the minimum supported version, upgrade it to ${MIN_CARGO_LAMBDA_VERSION} or newer`);
+  }
+
+  const compiler = localCompiler(options.compiler, options.architecture, options.runtime) ?? Compiler.CARGO_ZIGBUILD;
+  const compilerReady = compilerInstalled(compiler);
+  if (!compilerReady) {
+    localProblems.push(compiler === Compiler.CARGO_ZIGBUILD
+      ? 'the compiler `cargo-zigbuild` needs Zig, which is not installed, see https://ziglang.org/download'
+      : `the compiler \`${compiler}\` is not installed`);
+  }
+
+  const target = rustTarget(options.architecture, options.target);
+  const targetInstalled = compiler === Compiler.CROSS ? undefined : rustupTargetInstalled(target);
+  if (targetInstalled === false) {
+    localProblems.push(`the Rust target \`${target}\` is not installed, run \`rustup target add ${target}\``);
+  }
+
+  const docker = process.env.CDK_DOCKER ?? 'docker';
+  const dockerAvailable = commandSucceeds(docker, ['version', '--format', '{{.Server.Version}}']);
+  if (!dockerAvailable) {
+    dockerProblems.push(`Docker is not available, \`${docker} version\` failed to reach the Docker daemon`);
+  }
+
+  return {
+    cargoLambdaVersion: version,
+    localCompiler: compiler,
+    localCompilerInstalled: compilerReady,
+    rustTarget: target,
+    rustTargetInstalled: targetInstalled,
+    dockerAvailable,
+    canBuildLocally: localProblems.length === 0,
+    localProblems,
+    dockerProblems,
+  };
+}
+
+/**
+ * The reasons why the binary can't be built locally. Without the strict probe, any Cargo Lambda
+ * installation builds locally, like in previous versions, and the build reports the missing tools.
+ */
+export function localBuildProblems(capabilities: BundlingCapabilities, strict?: boolean): string[] {
+  if (strict) {
+    return capabilities.localProblems;
+  }
+  return capabilities.cargoLambdaVersion === undefined ? [CARGO_LAMBDA_NOT_INSTALLED] : [];
+}
+
+/**
+ * The version of Cargo Lambda installed locally, i.e. `1.2.1`.
+ */
+export function installedCargoLambdaVersion(): string | undefined {
+  try {
+    const cargo = spawnSync('cargo', ['lambda', '--version']);
+    if (cargo.status !== 0 || cargo.error) {
+      return undefined;
+    }
+    return cargo.stdout.toString().match(/(\d+\.\d+\.\d+)/)?.[1] ?? '0.0.0';
+  } catch (err) {
+    return undefined;
+  }
+}
+
+/**
+ * The compiler to use for local builds. With the automatic compiler, Cargo is used
+ * when the host can build for the architecture without cross compiling.
+ */
+export function localCompiler(compiler?: Compiler, architecture?: Architecture, runtime?: string): Compiler | undefined {
+  if (compiler !== Compiler.AUTO) {
+    return compiler;
+  }
+  // plain Cargo links against the GLIBC of the host, which can be newer than the runtime's
+  return hostMatchesArchitecture(architecture) && !runtimeTarget(runtime, architecture) ? Compiler.CARGO : undefined;
+}
+
+/**
+ * Check that plain Cargo builds for the architecture of the build environment, it doesn't cross compile.
+ * The Docker builds also run with the architecture of the host.
+ */
+export function validateCompilerArchitecture(compiler?: Compiler, architecture?: Architecture) {
+  const expected = architecture ?? Architecture.X86_64;
+  if (compiler === Compiler.CARGO && hostArchitecture() && hostArchitecture()?.name !== expected.name) {
+    throw new Error(`the compiler \`cargo\` doesn't cross compile, it cannot build for ${expected.name} on this ${process.arch} host, use the compiler \`cargo-zigbuild\`, or \`auto\` to use Cargo only when the host matches`);
+  }
+}
+
+/**
+ * The command that a compiler needs, besides Cargo.
+ */
+export function compilerCommand(compiler: string): string | undefined {
+  switch (compiler) {
+    case Compiler.CARGO_ZIGBUILD:
+      return 'zig';
+    case Compiler.CROSS:
+      return 'cross';
+    default:
+      return undefined;
+  }
+}
+
+/**
+ * Check whether the tools that a compiler needs are installed locally.
+ */
+export function compilerInstalled(compiler: string): boolean {
+  const command = compilerCommand(compiler);
+  return !command || commandSucceeds(command, [command === 'zig' ? 'version' : '--version']);
+}
+
+// the commands found in the Docker images, by image and command
+const imageCommands = new Map<string, boolean | undefined>();
+
+/**
+ * Check whether a command is installed in a Docker image, it's undefined when
+ * the image cannot run, i.e. it doesn't have a shell, and then the build reports the problem.
+ */
+export function imageHasCommand(image: string, command: string): boolean | undefined {
+  const key = JSON.stringify([image, command]);
+  if (!imageCommands.has(key)) {
+    const docker = process.env.CDK_DOCKER ?? 'docker';
+    const proc = spawnSync(docker, ['run', '--rm', '--entrypoint', 'sh', image, '-c', `if command -v ${command} > /dev/null; then echo found; else echo missing; fi`]);
+    // the exit status of `command -v` depends on the shell, and Docker uses statuses for its own errors
+    const output = proc.error || proc.status !== 0 ? '' : proc.stdout.toString().trim();
+    imageCommands.set(key, output === 'found' ? true : output === 'missing' ? false : undefined);
+  }
+  return imageCommands.get(key);
+}
+
+/**
+ * The Rust target triple for an architecture, without the glibc version suffix that Cargo Lambda accepts.
+ */
+export function rustTarget(architecture?: Architecture, target?: string): string {
+  if (target) {
+    return target.replace(/(\.\d+)+$/, '');
+  }
+  return architecture?.name === Architecture.ARM_64.name
+    ? 'aarch64-unknown-linux-gnu'
+    : 'x86_64-unknown-linux-gnu';
+}
+
+function rustupTargetInstalled(target: string): boolean | undefined {
+  try {
+    const rustup = spawnSync('rustup', ['target', 'list', '--installed']);
+    if (rustup.status !== 0 || rustup.error) {
+      return undefined;
+    }
+    return rustup.stdout.toString().split(/\r?\n/).map(line => line.trim()).includes(target);
+  } catch (err) {
+    return undefined;
+  }
+}
+
+function hostArchitecture(): Architecture | undefined {
+  return process.arch === 'arm64'
+    ? Architecture.ARM_64
+    : process.arch === 'x64' ? Architecture.X86_64 : undefined;
+}
+
+function hostMatchesArchitecture(architecture?: Architecture): boolean {
+  return platform() === 'linux' && hostArchitecture()?.name === (architecture ?? Architecture.X86_64).name;
+}
+
+function commandSucceeds(cmd: string, args: string[]): boolean {
+  try {
+    const proc = spawnSync(cmd, args);
+    return proc.status === 0 && !proc.error;
+  } catch (err) {
+    return false;
+  }
+}
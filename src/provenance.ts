@@ -0,0 +1,85 @@
+import { mkdirSync, writeFileSync } from 'node:fs';
+import { join } from 'node:path';
+import { Aspects, IAspect, Stage, Tags } from 'aws-cdk-lib';
+import { Construct, IConstruct } from 'constructs';
+import { GitProvenance, GitProvenanceOptions } from './types';
+
+/**
+ * The file in the cloud assembly with the git provenance of each construct.
+ */
+export const PROVENANCE_FILE = 'cargo-lambda-cdk.provenance.json';
+
+/**
+ * The metadata type of the git provenance in the construct metadata.
+ */
+export const PROVENANCE_METADATA = 'cargo-lambda-cdk:gitProvenance';
+
+// the Lambda limit of the function description
+const MAX_DESCRIPTION_LENGTH = 256;
+
+/**
+ * Attach the git provenance to a construct as metadata, and record it in the provenance file
+ * of the cloud assembly, next to the templates, when the app is synthesized.
+ */
+export function recordGitProvenance(construct: Construct, provenance: GitProvenance, options?: GitProvenanceOptions) {
+  construct.node.addMetadata(PROVENANCE_METADATA, { ...provenance });
+
+  if (options?.tags) {
+    Tags.of(construct).add('cargo-lambda-cdk:git-remote', provenance.remote);
+    Tags.of(construct).add('cargo-lambda-cdk:git-reference', provenance.reference);
+    Tags.of(construct).add('cargo-lambda-cdk:git-commit', provenance.commit);
+  }
+
+  const stage = Stage.of(construct);
+  if (stage && !recordedStages.has(stage)) {
+    recordedStages.add(stage);
+    Aspects.of(stage).add(new ProvenanceFile(stage));
+  }
+}
+
+const recordedStages = new WeakSet<Stage>();
+
+/**
+ * Write the provenance file of a stage from the metadata of its constructs, so the constructs
+ * that were removed since the previous synthesis are not in the file.
+ */
+class ProvenanceFile implements IAspect {
+  constructor(private readonly stage: Stage) {}
+
+  public visit(node: IConstruct) {
+    if (node !== this.stage) {
+      return;
+    }
+
+    const records: { [path: string]: GitProvenance } = {};
+    for (const construct of this.stage.node.findAll()) {
+      const metadata = construct.node.metadata.find(entry => entry.type === PROVENANCE_METADATA);
+      if (metadata && Stage.of(construct) === this.stage) {
+        records[construct.node.path] = metadata.data;
+      }
+    }
+
+    mkdirSync(this.stage.outdir, { recursive: true });
+    const sorted = Object.fromEntries(Object.entries(records).sort(([a], [b]) => a.localeCompare(b)));
+    writeFileSync(join(this.stage.outdir, PROVENANCE_FILE), JSON.stringify(sorted, null, 2) + '\n');
+  }
+}
+
+/**
+ * Append the git remote and commit to a description. The remote is left out when the description
+ * would be over the Lambda limit of 256 characters, and then the original description is shortened.
+ */
+export function provenanceDescription(provenance: GitProvenance, description?: string): string {
+  const full = withSuffix(`built from ${provenance.remote} at ${provenance.commit}`, description);
+  if (full.length <= MAX_DESCRIPTION_LENGTH) {
+    return full;
+  }
+
+  const suffix = `built at ${provenance.commit}`;
+  const room = MAX_DESCRIPTION_LENGTH - ` (${suffix})`.length;
+  return withSuffix(suffix, description && description.length > room ? `${description.slice(0, room - 3)}...` : description);
+}
+
+function withSuffix(suffix: string, description?: string): string {
+  return description ? `${description} (${suffix})` : suffix.charAt(0).toUpperCase() + suffix.slice(1);
+}
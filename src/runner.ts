@@ -0,0 +1,243 @@
+import { ChildProcess, spawn } from 'node:child_process';
+import { closeSync, mkdirSync, openSync, readFileSync, statSync, unlinkSync, writeSync } from 'node:fs';
+import { join } from 'node:path';
+import { Readable } from 'node:stream';
+
+const LOCK_FILE = '.cargo-lambda-cdk.lock';
+const LOCK_RETRY_MS = 500;
+// the time that a process has to write its pid into the lock file that it created
+const EMPTY_LOCK_STALE_MS = 5000;
+// the builds stop waiting for the lock of another process after this time
+const LOCK_TIMEOUT_MS = 30 * 60 * 1000;
+
+/**
+ * A build that runs in the prebuild phase.
+ */
+export interface BuildJob {
+  /**
+   * The path of the construct, it prefixes the output of the build.
+   */
+  readonly id: string;
+  readonly command: string;
+  readonly args: string[];
+  readonly cwd: string;
+  readonly env: { [key: string]: string | undefined };
+  readonly windowsVerbatimArguments?: boolean;
+
+  /**
+   * The Cargo target directory, only one build at a time can use it.
+   */
+  readonly lockDir: string;
+}
+
+export interface BuildResult {
+  readonly id: string;
+  readonly status: number | null;
+  readonly error?: string;
+}
+
+/**
+ * Run the builds with at most `concurrency` builds at the same time. The builds that use
+ * the same target directory run one after the other, and wait for the builds in other
+ * processes that hold the lock of the target directory, for at most `lockTimeout` milliseconds.
+ */
+export function runBuilds(jobs: BuildJob[], concurrency: number, lockTimeout: number = LOCK_TIMEOUT_MS): Promise<BuildResult[]> {
+  const pending = [...jobs];
+  const lockedDirs = new Set<string>();
+  // when the builds of each target directory started to wait for another process
+  const waitingSince = new Map<string, number>();
+  const results: BuildResult[] = [];
+  let running = 0;
+
+  return new Promise(resolve => {
+    const tryLock = (lockDir: string) => {
+      if (lockedDirs.has(lockDir)) {
+        return false;
+      }
+      if (acquireLock(lockDir)) {
+        waitingSince.delete(lockDir);
+        return true;
+      }
+      if (!waitingSince.has(lockDir)) {
+        waitingSince.set(lockDir, Date.now());
+      }
+      return false;
+    };
+
+    // the builds that waited too long for the lock of another process fail
+    const giveUp = () => {
+      for (const [lockDir, since] of waitingSince) {
+        if (Date.now() - since < lockTimeout || lockedDirs.has(lockDir)) {
+          continue;
+        }
+        waitingSince.delete(lockDir);
+        const error = lockTimeoutError(lockDir, lockTimeout);
+        for (const job of pending.filter(waiting => waiting.lockDir === lockDir)) {
+          pending.splice(pending.indexOf(job), 1);
+          results.push({ id: job.id, status: null, error });
+        }
+      }
+    };
+
+    const schedule = () => {
+      while (running < Math.max(1, concurrency)) {
+        const index = pending.findIndex(job => tryLock(job.lockDir));
+        if (index === -1) {
+          break;
+        }
+
+        const [job] = pending.splice(index, 1);
+        lockedDirs.add(job.lockDir);
+        running++;
+        runBuild(job).then(result => {
+          releaseLock(job.lockDir);
+          lockedDirs.delete(job.lockDir);
+          running--;
+          results.push(result);
+          schedule();
+        });
+      }
+
+      giveUp();
+      if (pending.length === 0 && running === 0) {
+        resolve(results);
+      } else if (pending.length > 0 && running === 0) {
+        // the remaining builds wait for the locks that other processes hold
+        setTimeout(schedule, LOCK_RETRY_MS);
+      }
+    };
+
+    if (jobs.length === 0) {
+      resolve([]);
+    } else {
+      schedule();
+    }
+  });
+}
+
+function runBuild(job: BuildJob): Promise<BuildResult> {
+  return new Promise(resolve => {
+    let child: ChildProcess;
+    try {
+      child = spawn(job.command, job.args, {
+        cwd: job.cwd,
+        env: job.env,
+        stdio: ['ignore', 'pipe', 'pipe'],
+        windowsVerbatimArguments: job.windowsVerbatimArguments,
+      });
+    } catch (err) {
+      resolve({ id: job.id, status: null, error: `${err}` });
+      return;
+    }
+
+    prefixLines(job.id, child.stdout!);
+    prefixLines(job.id, child.stderr!);
+    child.on('error', err => resolve({ id: job.id, status: null, error: `${err}` }));
+    child.on('close', status => resolve({ id: job.id, status }));
+  });
+}
+
+/**
+ * Write the output of a build to stderr, with each line prefixed with the construct path,
+ * so the output of the builds that run at the same time stays readable.
+ */
+function prefixLines(id: string, stream: Readable) {
+  let buffer = '';
+  stream.setEncoding('utf-8');
+  stream.on('data', (chunk: string) => {
+    const lines = (buffer + chunk).split(/\r?\n/);
+    buffer = lines.pop()!;
+    lines.forEach(line => process.stderr.write(`[${id}] ${line}\n`));
+  });
+  stream.on('end', () => {
+    if (buffer) {
+      process.stderr.write(`[${id}] ${buffer}\n`);
+    }
+  });
+}
+
+/**
+ * Take the lock of a target directory, the lock file contains the pid of its owner,
+ * and the lock of a process that doesn't exist anymore is taken over, like an empty lock file
+ * of a process that stopped before it wrote its pid.
+ */
+function acquireLock(lockDir: string): boolean {
+  const lockFile = join(lockDir, LOCK_FILE);
+  mkdirSync(lockDir, { recursive: true });
+
+  for (let attempt = 0; attempt < 2; attempt++) {
+    try {
+      const fd = openSync(lockFile, 'wx');
+      writeSync(fd, `${process.pid}`);
+      closeSync(fd);
+      return true;
+    } catch (err: any) {
+      if (err.code !== 'EEXIST') {
+        return false;
+      }
+      // an empty lock file was just created by another process that didn't write its pid yet
+      const owner = readLock(lockFile);
+      if (owner ? isAlive(Number(owner)) : !isStaleEmptyLock(lockFile)) {
+        return false;
+      }
+      try {
+        unlinkSync(lockFile);
+      } catch (unlinkErr) {
+        // another process took over the lock first
+      }
+    }
+  }
+  return false;
+}
+
+function releaseLock(lockDir: string) {
+  try {
+    unlinkSync(join(lockDir, LOCK_FILE));
+  } catch (err) {
+    // the lock was already removed
+  }
+}
+
+function isStaleEmptyLock(lockFile: string): boolean {
+  const stat = statSync(lockFile, { throwIfNoEntry: false });
+  return !!stat && Date.now() - stat.mtimeMs > EMPTY_LOCK_STALE_MS;
+}
+
+function lockTimeoutError(lockDir: string, lockTimeout: number): string {
+  const lockFile = join(lockDir, LOCK_FILE);
+  const owner = readLock(lockFile);
+  const seconds = Math.ceil(lockTimeout / 1000);
+  return `the target directory is locked by ${owner ? `the process ${owner}` : 'another process'}, `
+    + `gave up after waiting ${seconds}s, remove the lock file \`${lockFile}\` if that process is not building`;
+}
+
+function readLock(lockFile: string): string {
+  try {
+    return readFileSync(lockFile, 'utf-8');
+  } catch (err) {
+    return '';
+  }
+}
+
+function isAlive(pid: number): boolean {
+  if (!pid) {
+    return false;
+  }
+  try {
+    process.kill(pid, 0);
+    return true;
+  } catch (err: any) {
+    return err.code === 'EPERM';
+  }
+}
+
+/**
+ * The entry point of the process that runs the prebuild phase: it reads the builds from stdin,
+ * and writes the results to stdout.
+ */
+export function main() {
+  const { jobs, concurrency } = JSON.parse(readFileSync(0, 'utf-8'));
+  runBuilds(jobs, concurrency).then(results => {
+    process.stdout.write(JSON.stringify(results));
+  });
+}
@@ -0,0 +1,201 @@
+import { spawnSync } from 'node:child_process';
+import { createHash } from 'node:crypto';
+import { mkdirSync } from 'node:fs';
+import { homedir } from 'node:os';
+import { join, resolve } from 'node:path';
+import { DockerVolume } from 'aws-cdk-lib';
+import { CompilerCacheOptions } from './types';
+
+const CONTAINER_SCCACHE_DIR = '/cargo-lambda-cdk/sccache';
+
+// the default port of sccache is 4226, the servers of the builds use the ports after it
+const SCCACHE_FIRST_PORT = 4227;
+const SCCACHE_PORTS = 20000;
+
+// prints the hits and misses of `sccache --show-stats`
+const SCCACHE_STATS_SUMMARY = 'awk \'/^Cache hits +[0-9]/ { hits = $3 } /^Cache misses +[0-9]/ { misses = $3 } END { print "sccache: " hits " hits, " misses " misses" }\' >&2';
+
+/**
+ * The hit and miss statistics of the sccache server.
+ */
+export interface SccacheStats {
+  readonly hits: number;
+  readonly misses: number;
+}
+
+/**
+ * The directory where sccache stores the compiled crates.
+ */
+export function sccacheDirectory(options: CompilerCacheOptions): string {
+  return resolve(options.directory ?? join(homedir(), '.cache', 'cargo-lambda-cdk', 'sccache'));
+}
+
+function sccacheEnvironment(options: CompilerCacheOptions, directory: string): { [key: string]: string } {
+  return {
+    SCCACHE_DIR: directory,
+    ...(options.maxSize ? { SCCACHE_CACHE_SIZE: `${Math.floor(options.maxSize.toBytes() / (1024 * 1024))}M` } : {}),
+  };
+}
+
+// finds the first port from the arguments that nothing listens on, the ports are tried one after the other
+const FIND_FREE_PORT = `
+const net = require('net');
+const ports = process.argv.slice(1).map(Number);
+const tryPort = index => {
+  if (index >= ports.length) { process.exit(1); }
+  const server = net.createServer();
+  server.once('error', () => tryPort(index + 1));
+  server.listen(ports[index], '127.0.0.1', () => server.close(() => console.log(ports[index])));
+};
+tryPort(0);
+`;
+
+// the number of ports after the port of the job that are tried when it is not free
+const SCCACHE_PORT_ATTEMPTS = 20;
+
+// the ports given to the builds of this process, the servers of these builds may not be running yet
+const reservedPorts = new Set<number>();
+
+/**
+ * The port of a dedicated sccache server. A running server keeps the cache directory and size
+ * that it started with, so the builds with other options must use another server.
+ */
+export function sccacheServerPort(...keys: string[]): number {
+  const hash = createHash('sha256').update(JSON.stringify(keys)).digest();
+  return SCCACHE_FIRST_PORT + hash.readUInt32BE(0) % SCCACHE_PORTS;
+}
+
+/**
+ * The port of the sccache server of a single build, starting from the port of its keys,
+ * that no other process listens on, and that no other build of this process uses.
+ */
+export function freeSccacheServerPort(...keys: string[]): number {
+  const first = sccacheServerPort(...keys);
+  const candidates = [...Array(SCCACHE_PORT_ATTEMPTS).keys()]
+    .map(offset => SCCACHE_FIRST_PORT + (first - SCCACHE_FIRST_PORT + offset) % SCCACHE_PORTS)
+    .filter(port => !reservedPorts.has(port));
+
+  const probe = spawnSync(process.execPath, ['-e', FIND_FREE_PORT, ...candidates.map(port => `${port}`)]);
+  const port = probe.status === 0 ? parseInt(probe.stdout.toString().trim(), 10) : undefined;
+  if (!port) {
+    throw new Error(`no free port for the sccache server of the build, the ports ${candidates[0]} to ${candidates[candidates.length - 1]} are in use`);
+  }
+  reservedPorts.add(port);
+  return port;
+}
+
+// sccache is only looked up once per synthesis, and a missing sccache is only reported once
+let localSccache: boolean | undefined;
+
+/**
+ * The environment that compiles the crates with sccache in local builds, or undefined
+ * when sccache is not installed, or when the build already has a different `RUSTC_WRAPPER`,
+ * so the build runs without it.
+ *
+ * The builds use a server dedicated to the cache directory and size, not the server
+ * that the user may already run with other options.
+ */
+export function localSccacheEnvironment(
+  options: CompilerCacheOptions,
+  environment: { [key: string]: string | undefined } = process.env,
+): { [key: string]: string } | undefined {
+  const wrapper = environment.RUSTC_WRAPPER;
+  if (wrapper && wrapper !== 'sccache') {
+    process.stderr.write(`RUSTC_WRAPPER is already set to \`${wrapper}\`, the Rust build runs with it and without the compiler cache.\n`);
+    return undefined;
+  }
+
+  if (localSccache === undefined) {
+    const version = spawnSync('sccache', ['--version']);
+    localSccache = !version.error && version.status === 0;
+    if (!localSccache) {
+      process.stderr.write('sccache is not installed, the Rust builds run without the compiler cache. Install it with `cargo install sccache`.\n');
+    }
+  }
+
+  if (!localSccache) {
+    return undefined;
+  }
+  const sccache = sccacheEnvironment(options, sccacheDirectory(options));
+  return {
+    RUSTC_WRAPPER: 'sccache',
+    SCCACHE_SERVER_PORT: `${sccacheServerPort(sccache.SCCACHE_DIR, sccache.SCCACHE_CACHE_SIZE ?? '')}`,
+    ...sccache,
+  };
+}
+
+/**
+ * Run the command of a build in the prebuild phase with its own sccache server, and report
+ * the hits and misses of the build. The builds that run at the same time have their own server,
+ * so the statistics of the server are the statistics of the build.
+ *
+ * The server is only stopped when the build started it, a server that was already running
+ * on the port belongs to someone else.
+ */
+export function prebuildSccacheCommand(command: string): string {
+  return [
+    'if sccache --start-server > /dev/null 2>&1; then started=1; fi',
+    `(${command})`,
+    'status=$?',
+    `if [ "$status" = 0 ]; then sccache --show-stats | ${SCCACHE_STATS_SUMMARY}; fi`,
+    'if [ -n "$started" ]; then sccache --stop-server > /dev/null 2>&1; fi',
+    'exit $status',
+  ].join('; ');
+}
+
+/**
+ * The volume, the environment and the commands that compile the crates with sccache in Docker builds.
+ *
+ * The image might not have sccache installed, so `RUSTC_WRAPPER` is only set in the container
+ * when the `sccache` command exists.
+ */
+export function dockerSccache(options: CompilerCacheOptions) {
+  const directory = sccacheDirectory(options);
+  const volume: DockerVolume = { hostPath: directory, containerPath: CONTAINER_SCCACHE_DIR };
+
+  return {
+    volume,
+    environment: sccacheEnvironment(options, CONTAINER_SCCACHE_DIR),
+    setup: 'if command -v sccache > /dev/null; then export RUSTC_WRAPPER=sccache; '
+      + 'else echo "sccache is not installed in the bundling image, the Rust build runs without the compiler cache" >&2; fi',
+    // the sccache server starts in the container, so its statistics are the statistics of this build
+    report: `(if [ "$RUSTC_WRAPPER" = sccache ]; then sccache --show-stats | ${SCCACHE_STATS_SUMMARY}; fi; true)`,
+    // the directory is created by the current user, so it already belongs to the user in the container
+    prepare: () => mkdirSync(directory, { recursive: true }),
+  };
+}
+
+/**
+ * Read the statistics of the local sccache server, which starts the server with
+ * the cache directory in the environment when it's not running yet.
+ */
+export function sccacheStats(environment: { [key: string]: string | undefined }): SccacheStats | undefined {
+  const proc = spawnSync('sccache', ['--show-stats', '--stats-format', 'json'], { env: environment });
+  if (proc.error || proc.status !== 0) {
+    return undefined;
+  }
+
+  try {
+    const stats = JSON.parse(proc.stdout.toString()).stats;
+    return {
+      hits: countAll(stats.cache_hits),
+      misses: countAll(stats.cache_misses),
+    };
+  } catch (err) {
+    return undefined;
+  }
+}
+
+function countAll(counter?: { counts?: { [language: string]: number } }): number {
+  return Object.values(counter?.counts ?? {}).reduce((total, count) => total + count, 0);
+}
+
+/**
+ * Report the hits and misses of a local build, from the statistics before and after the build.
+ */
+export function reportSccacheStats(before?: SccacheStats, after?: SccacheStats) {
+  if (!before || !after) {
+    return;
+  }
+  process.stderr.write(`sccache: ${after.hits - before.hits} hits, ${after.misses - before.misses} misses\n`);
+}
@@ -0,0 +1,67 @@
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { Compiler } from './types';
+import { compareVersions } from './util';
+
+/**
+ * The GLIBC version in each Lambda OS-only runtime.
+ */
+export const RUNTIME_GLIBC_VERSIONS: { [runtime: string]: string } = {
+  'provided.al2': '2.26',
+  'provided.al2023': '2.34',
+};
+
+const TARGET_PATTERN = /^(x86_64|aarch64)-unknown-linux-(gnu|musl)(?:\.(\d+\.\d+))?$/;
+
+/**
+ * The target triple to build for a runtime, when the runtime needs an older GLIBC
+ * than the one that Cargo Lambda links against by default.
+ *
+ * The GLIBC version suffix is only supported by `cargo-zigbuild`, other compilers use
+ * the GLIBC version of the host or the Docker image.
+ */
+export function runtimeTarget(runtime?: string, architecture?: Architecture, compiler?: string): string | undefined {
+  if (runtime !== 'provided.al2' || (compiler !== undefined && compiler !== Compiler.CARGO_ZIGBUILD)) {
+    return undefined;
+  }
+
+  const arch = architecture?.name === Architecture.ARM_64.name ? 'aarch64' : 'x86_64';
+  return `${arch}-unknown-linux-gnu.${RUNTIME_GLIBC_VERSIONS[runtime]}`;
+}
+
+/**
+ * Check that the compiler can build a binary for the GLIBC of the runtime. Only `cargo-zigbuild`
+ * builds for older GLIBC versions, plain Cargo and cross link against the GLIBC of the build
+ * environment, which is newer than the one in `provided.al2`.
+ */
+export function validateRuntimeCompiler(runtime?: string, compiler?: string, target?: string) {
+  if (!runtimeTarget(runtime) || (compiler !== Compiler.CARGO && compiler !== Compiler.CROSS) || target?.endsWith('-musl')) {
+    return;
+  }
+  throw new Error(`the compiler \`${compiler}\` builds the binary for the GLIBC of the build environment, but the runtime ${runtime} provides GLIBC ${RUNTIME_GLIBC_VERSIONS[runtime!]}, use the compiler \`cargo-zigbuild\`, or a musl target`);
+}
+
+/**
+ * Check that a target triple runs on Lambda with the architecture and the runtime.
+ */
+export function validateTarget(target: string, architecture?: Architecture, runtime?: string) {
+  const match = target.match(TARGET_PATTERN);
+  if (!match) {
+    throw new Error(`the target \`${target}\` is not supported, use a Linux target for x86_64 or aarch64, i.e. \`x86_64-unknown-linux-gnu\` or \`aarch64-unknown-linux-musl\``);
+  }
+
+  const [, arch, env, glibc] = match;
+  const expected = architecture ?? Architecture.X86_64;
+  const expectedArch = expected.name === Architecture.ARM_64.name ? 'aarch64' : 'x86_64';
+  if (arch !== expectedArch) {
+    throw new Error(`the target \`${target}\` doesn't match the architecture ${expected.name}, use a target that starts with \`${expectedArch}-\``);
+  }
+
+  if (glibc && env !== 'gnu') {
+    throw new Error(`the target \`${target}\` has a GLIBC version, but it's not a GNU target`);
+  }
+
+  const runtimeGlibc = runtime ? RUNTIME_GLIBC_VERSIONS[runtime] : undefined;
+  if (glibc && runtimeGlibc && compareVersions(glibc, runtimeGlibc) > 0) {
+    throw new Error(`the target \`${target}\` requires GLIBC ${glibc}, but the runtime ${runtime} provides GLIBC ${runtimeGlibc}`);
+  }
+}
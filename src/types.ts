@@ -1,4 +1,4 @@
-import { AssetHashType, DockerImage } from 'aws-cdk-lib';
+import { AssetHashType, DockerImage, Size } from 'aws-cdk-lib';
 import { Architecture } from 'aws-cdk-lib/aws-lambda';
 import {
   BundlingFileAccess,
@@ -67,8 +67,8 @@ export interface DockerOptions {
    * Local bundling provider. This is normally controlled by the `RustFunction`
    * but can be overridden here.
    *
-   * @default - bundling will be performed locally if Rust and cargo-lambda are
-   * installed and `forcedDockerBundling` is not true, otherwise it will be performed
+   * @default - depends on the bundling `mode`, in `auto` mode bundling will be performed
+   * locally if Rust and cargo-lambda can build for the target, otherwise it will be performed
    * in the docker container
    */
   readonly local?: ILocalBundling;
@@ -104,6 +104,73 @@ export interface DockerOptions {
   readonly bundlingFileAccess?: BundlingFileAccess;
 }
 
+/**
+ * Where to cache the Cargo downloads and the target directory between Docker builds.
+ *
+ * The cache is opt-in, it's only used when the `dockerCache` option is set,
+ * i.e. `dockerCache: {}` for the default Docker volumes.
+ */
+export interface DockerCacheOptions {
+  /**
+   * The `CARGO_HOME` of the Docker image. The downloads are cached in its `registry`
+   * and `git` directories, the rest of the directory comes from the image.
+   *
+   * @default /usr/local/cargo, the `CARGO_HOME` of the default image
+   */
+  readonly cargoHome?: string;
+
+  /**
+   * The prefix of the Docker volume names.
+   *
+   * @default cargo-lambda-cdk
+   */
+  readonly volumePrefix?: string;
+
+  /**
+   * Store the caches in this host directory instead of Docker volumes.
+   *
+   * @default - the caches are stored in Docker volumes
+   */
+  readonly hostDirectory?: string;
+}
+
+/**
+ * Where sccache stores the compiled crates.
+ */
+export interface CompilerCacheOptions {
+  /**
+   * The directory that stores the compiled crates. In Docker builds, the directory is mounted in the container.
+   *
+   * @default - the `.cache/cargo-lambda-cdk/sccache` directory in the home directory
+   */
+  readonly directory?: string;
+
+  /**
+   * The maximum size of the cache.
+   *
+   * @default - the sccache default, 10 GiB
+   */
+  readonly maxSize?: Size;
+}
+
+/**
+ * Where to store the outputs of the builds, to reuse them in other apps and stacks.
+ */
+export interface BuildCacheOptions {
+  /**
+   * The directory that stores the outputs of the builds, keyed by their fingerprint.
+   */
+  readonly directory: string;
+
+  /**
+   * The maximum size of the cache. When a build makes the cache bigger,
+   * the least recently used outputs are removed.
+   *
+   * @default Size.gibibytes(5)
+   */
+  readonly maxSize?: Size;
+}
+
 /**
  * Bundling options
  */
@@ -123,6 +190,65 @@ export interface BundlingOptions {
    */
   readonly forcedDockerBundling?: boolean;
 
+  /**
+   * How to build the binary: locally with Cargo Lambda, in a Docker container,
+   * or not at all, using the output of a previous `cargo lambda build`.
+   *
+   * In `auto` mode, the binary is built locally when Cargo Lambda is installed,
+   * and in a Docker container otherwise.
+   *
+   * @default - `docker` if `forcedDockerBundling` is true, `auto` otherwise
+   */
+  readonly mode?: BundlingMode;
+
+  /**
+   * Check that the tools for the compiler and the Rust target are installed before building locally,
+   * not only Cargo Lambda.
+   *
+   * In `auto` mode, the binary is then built in a Docker container when the local toolchain
+   * can't build for the target.
+   *
+   * @default false
+   */
+  readonly probeLocalToolchain?: boolean;
+
+  /**
+   * Path to the output of a previous `cargo lambda build` to deploy instead of building the binary.
+   *
+   * This can be a zip file, a directory with the `bootstrap` binary (or with the `extensions`
+   * directory for extensions), or the Cargo Lambda output directory, i.e. `target/lambda`,
+   * with a directory for each binary. The artifact must contain the binary, and the binary
+   * must be built for the function's architecture.
+   *
+   * Setting this option selects the `prebuilt` bundling mode.
+   *
+   * @default - the `target/lambda` directory in the Cargo workspace in `prebuilt` mode
+   */
+  readonly prebuiltArtifact?: string;
+
+  /**
+   * The target triple to build for, it takes precedence over the architecture.
+   *
+   * Use a musl target, i.e. `x86_64-unknown-linux-musl`, to build a statically linked binary,
+   * or a GNU target with a GLIBC version suffix, i.e. `aarch64-unknown-linux-gnu.2.26`,
+   * to link against an older GLIBC with `cargo-zigbuild`. The target must match the architecture.
+   *
+   * @default - the GNU target for the architecture, with the GLIBC version of the
+   * runtime for `provided.al2` when the compiler is `cargo-zigbuild`
+   */
+  readonly target?: string;
+
+  /**
+   * What to do when the verification of the bundle finds a problem.
+   *
+   * After bundling, the binaries are checked for the function's architecture, for GLIBC
+   * versions that the runtime doesn't provide, and the bundle is checked against the
+   * Lambda limits of 50 MB zipped and 250 MB unzipped.
+   *
+   * @default VerificationSeverity.WARNING
+   */
+  readonly verification?: VerificationSeverity;
+
   /**
    * A custom bundling Docker image.
    *
@@ -137,12 +263,43 @@ export interface BundlingOptions {
    */
   readonly dockerOptions?: DockerOptions;
 
+  /**
+   * Cache the Cargo downloads and the target directory between Docker builds.
+   *
+   * The crates downloaded from the registries and git repositories are shared with all
+   * the projects, and each project has its own target directory. The caches are stored in
+   * Docker volumes with the `cargo-lambda-cdk` prefix, unless the options say otherwise.
+   *
+   * @default - every Docker build starts from a clean container
+   */
+  readonly dockerCache?: DockerCacheOptions;
+
+  /**
+   * Store the outputs of the builds in a local cache, and reuse them in the builds
+   * with the same fingerprint, in this and other CDK apps. The cache directory can also be
+   * set with the `cargo-lambda-cdk:buildCache` context key.
+   *
+   * @default - the outputs are not cached outside of the cloud assembly
+   */
+  readonly buildCache?: BuildCacheOptions;
+
+  /**
+   * Compile the crates with sccache, to reuse the crates compiled by other builds.
+   * The hits and misses of the cache are reported after each build.
+   *
+   * When sccache is not installed, locally or in the bundling image, the build runs without it.
+   *
+   * @default - the crates are not cached with sccache
+   */
+  readonly compilerCache?: CompilerCacheOptions;
+
   /**
    * Determines how the asset hash is calculated. Assets will
    * get rebuilt and uploaded only if their hash has changed.
    *
-   * @default - AssetHashType.OUTPUT. If `assetHash` is also specified,
-   * the default is `CUSTOM`.
+   * @default - the fingerprint of the build, from the sources, the bundling options and the
+   * toolchain, or `AssetHashType.OUTPUT` for prebuilt artifacts. If `assetHash` is also
+   * specified, the default is `CUSTOM`.
    */
   readonly assetHashType?: AssetHashType;
 
@@ -187,6 +344,367 @@ export interface BundlingOptions {
    * @default - `release`
    */
   readonly profile?: string;
+
+  /**
+   * The compiler that Cargo Lambda uses to cross compile the binary.
+   *
+   * @default - Compiler.CARGO_ZIGBUILD, the default compiler in Cargo Lambda
+   */
+  readonly compiler?: Compiler;
+
+  /**
+   * Cargo features to activate.
+   *
+   * @default - only the default features are activated
+   */
+  readonly features?: string[];
+
+  /**
+   * Do not activate the `default` feature.
+   *
+   * @default - false
+   */
+  readonly noDefaultFeatures?: boolean;
+
+  /**
+   * Additional files and directories to include in the bundling output, next to the `bootstrap` binary.
+   *
+   * @default - only the binary is included
+   */
+  readonly include?: IncludeFile[];
+
+  /**
+   * Activate all available features.
+   *
+   * @default - false
+   */
+  readonly allFeatures?: boolean;
+
+  /**
+   * Build variants for different stages, keyed by stage name.
+   *
+   * The variant that matches the value of the CDK context key `variantContextKey`
+   * overrides the features and profile specified in these bundling options.
+   *
+   * @default - the same features and profile are used for all stages
+   */
+  readonly variants?: { [stage: string]: BuildVariant };
+
+  /**
+   * The CDK context key that selects the build variant, i.e. `cdk deploy -c stage=prod`.
+   *
+   * @default - `stage`
+   */
+  readonly variantContextKey?: string;
+}
+
+/**
+ * Where the value of a build setting comes from.
+ */
+export enum BuildSettingSource {
+  /**
+   * The bundling options of the construct.
+   */
+  BUNDLING_OPTIONS = 'bundlingOptions',
+
+  /**
+   * The `[build]` section in CargoLambda.toml.
+   */
+  CARGO_LAMBDA_TOML = 'CargoLambda.toml',
+
+  /**
+   * The `[package.metadata.lambda.build]` section in Cargo.toml.
+   */
+  CARGO_TOML = 'Cargo.toml',
+}
+
+/**
+ * A build setting resolved from the bundling options and the Cargo Lambda build configuration.
+ */
+export interface BuildSetting {
+  /**
+   * The name of the setting, i.e. `compiler`.
+   */
+  readonly name: string;
+
+  /**
+   * The value of the setting. Lists are joined with commas.
+   */
+  readonly value: string;
+
+  /**
+   * Where the value comes from.
+   */
+  readonly source: BuildSettingSource;
+}
+
+/**
+ * Compilers that Cargo Lambda can use to build the binary.
+ */
+export enum Compiler {
+  /**
+   * Use plain Cargo when the host already matches the architecture and operating system
+   * of the function, and Cargo Lambda's default compiler otherwise, or when the runtime
+   * is `provided.al2`, which needs a binary built for its older GLIBC.
+   * Docker bundling always uses Cargo Lambda's default compiler.
+   */
+  AUTO = 'auto',
+
+  /**
+   * Cross compile with cargo-zigbuild. It requires `zig` to be installed.
+   */
+  CARGO_ZIGBUILD = 'cargo-zigbuild',
+
+  /**
+   * Cross compile with cross. It requires `cross` to be installed, and
+   * it's not available in the default Docker image.
+   */
+  CROSS = 'cross',
+
+  /**
+   * Compile with plain Cargo, without cross compilation. It can only build for the architecture of the host.
+   */
+  CARGO = 'cargo',
+}
+
+/**
+ * A file, directory or glob to include in the bundling output.
+ */
+export interface IncludeFile {
+  /**
+   * Path or glob relative to the directory with the Cargo.toml file, i.e. `templates/*.html`.
+   *
+   * Globs support `*`, `?` and `**`.
+   */
+  readonly source: string;
+
+  /**
+   * Path relative to the root of the bundling output.
+   *
+   * For a single file or directory, this is the path to copy it to.
+   * For a glob, this is the directory to copy the matches to, keeping
+   * their paths relative to the part of the source before the first wildcard.
+   *
+   * @default - the same path as the source, relative to the directory with the Cargo.toml file
+   */
+  readonly destination?: string;
+}
+
+/**
+ * The Lambda OS-only runtimes that run Rust functions.
+ */
+export enum RustRuntime {
+  /**
+   * Amazon Linux 2023, with GLIBC 2.34.
+   */
+  PROVIDED_AL2023 = 'provided.al2023',
+
+  /**
+   * Amazon Linux 2, with GLIBC 2.26.
+   */
+  PROVIDED_AL2 = 'provided.al2',
+}
+
+/**
+ * How to produce the binary for a function.
+ */
+export enum BundlingMode {
+  /**
+   * Build locally when Cargo Lambda is installed, in a Docker container otherwise.
+   */
+  AUTO = 'auto',
+
+  /**
+   * Always build locally, and fail if Cargo Lambda is not installed.
+   */
+  LOCAL = 'local',
+
+  /**
+   * Always build in a Docker container, and fail if Docker is not available.
+   */
+  DOCKER = 'docker',
+
+  /**
+   * Don't build, use the output of a previous `cargo lambda build` in the `target/lambda` directory,
+   * or in the `prebuiltArtifact` path.
+   */
+  PREBUILT = 'prebuilt',
+}
+
+/**
+ * Authentication and checkout options for git sources.
+ */
+export interface GitOptions {
+  /**
+   * Path to the private SSH key to clone the repository with.
+   *
+   * @default - the SSH keys and agent configured in the environment
+   */
+  readonly sshKeyPath?: string;
+
+  /**
+   * The name of the environment variable with a token to clone the repository over HTTPS,
+   * i.e. `GITHUB_TOKEN`. The token is read by a git credential helper when git runs,
+   * and it never appears in the command line.
+   *
+   * @default - no token
+   */
+  readonly tokenEnvironmentVariable?: string;
+
+  /**
+   * The username to authenticate with the token.
+   *
+   * @default x-access-token
+   */
+  readonly tokenUsername?: string;
+
+  /**
+   * A git credential helper to authenticate with, i.e. `store` or `!aws codecommit credential-helper $@`.
+   * It replaces the credential helpers in the git configuration.
+   *
+   * @default - the credential helpers in the git configuration
+   */
+  readonly credentialHelper?: string;
+
+  /**
+   * Clone the submodules of the repository.
+   *
+   * @default false
+   */
+  readonly recurseSubmodules?: boolean;
+
+  /**
+   * Check out only the directories needed to build the package: the package's directory,
+   * the workspace root, the workspace members and the path dependencies.
+   * The files in the root directory of the repository are always checked out.
+   *
+   * @default false
+   */
+  readonly sparseCheckout?: boolean;
+
+  /**
+   * The directory to clone the repository into. Each reference is cloned into
+   * a directory named after the commit, and it's reused in later synthesis,
+   * so the synthesis works offline once the commit is cloned.
+   *
+   * @default - the temporary directory of the OS
+   */
+  readonly cacheDirectory?: string;
+
+  /**
+   * What to do with the clones of the commits that the reference pointed to before.
+   *
+   * @default GitCacheCleanup.KEEP
+   */
+  readonly cacheCleanup?: GitCacheCleanup;
+}
+
+/**
+ * The git commit that a construct was built from.
+ */
+export interface GitProvenance {
+  /**
+   * The git remote that the source code was cloned from.
+   */
+  readonly remote: string;
+
+  /**
+   * The git reference that was requested, i.e. a branch, a tag or `HEAD`.
+   */
+  readonly reference: string;
+
+  /**
+   * The commit that the reference resolved to.
+   */
+  readonly commit: string;
+}
+
+/**
+ * Where to record the git provenance of a construct built from a git remote,
+ * besides the construct metadata and the provenance file in the cloud assembly.
+ */
+export interface GitProvenanceOptions {
+  /**
+   * Tag the function with the git remote, reference and commit.
+   *
+   * @default false
+   */
+  readonly tags?: boolean;
+
+  /**
+   * Append the git remote and commit to the description.
+   *
+   * @default false
+   */
+  readonly description?: boolean;
+}
+
+/**
+ * What to do with the cached clones of a git reference when it resolves to a new commit.
+ */
+export enum GitCacheCleanup {
+  /**
+   * Keep the clones of the previous commits.
+   */
+  KEEP = 'keep',
+
+  /**
+   * Remove the clones of the previous commits, and keep only the latest one.
+   */
+  KEEP_LATEST = 'keepLatest',
+}
+
+/**
+ * What to do with the problems that the bundle verification finds.
+ */
+export enum VerificationSeverity {
+  /**
+   * Fail the synthesis.
+   */
+  ERROR = 'error',
+
+  /**
+   * Report each problem as a warning annotation.
+   */
+  WARNING = 'warning',
+
+  /**
+   * Don't verify the bundle.
+   */
+  IGNORE = 'ignore',
+}
+
+/**
+ * Features and profile to build a binary with in a specific stage.
+ */
+export interface BuildVariant {
+  /**
+   * Cargo features to activate.
+   *
+   * @default - the features in the bundling options
+   */
+  readonly features?: string[];
+
+  /**
+   * Do not activate the `default` feature.
+   *
+   * @default - the value in the bundling options
+   */
+  readonly noDefaultFeatures?: boolean;
+
+  /**
+   * Activate all available features.
+   *
+   * @default - the value in the bundling options
+   */
+  readonly allFeatures?: boolean;
+
+  /**
+   * Specify the Cargo Build profile to use.
+   *
+   * @default - the profile in the bundling options
+   */
+  readonly profile?: string;
 }
 
 /**
@@ -1,7 +1,11 @@
 import { spawnSync, SpawnSyncOptions } from 'child_process';
 import * as lambda from 'aws-cdk-lib/aws-lambda';
+import { Construct } from 'constructs';
+import { buildCacheOptions } from './buildcache';
+import { RustExtensionProps } from './extension';
 import { RustFunctionProps } from './function';
-import { BundlingOptions } from './types';
+import { BundlingOptions, RustRuntime } from './types';
+import { RustWorkspace } from './workspace';
 
 /**
  * Spawn sync with error handling
@@ -36,6 +40,118 @@ export function bundlingOptionsFromRustFunctionProps(props?: RustFunctionProps):
       : lambda.Architecture.X86_64;
   return {
     ...props?.bundling,
+    features: functionOption('features', props?.features, props?.bundling?.features),
+    noDefaultFeatures: functionOption('noDefaultFeatures', props?.noDefaultFeatures, props?.bundling?.noDefaultFeatures),
+    allFeatures: functionOption('allFeatures', props?.allFeatures, props?.bundling?.allFeatures),
     architecture,
   };
 }
+
+function functionOption<T>(name: string, value: T | undefined, bundlingValue: T | undefined): T | undefined {
+  if (value !== undefined && bundlingValue !== undefined && JSON.stringify(value) !== JSON.stringify(bundlingValue)) {
+    throw new Error(`the option \`${name}\` of the function (${JSON.stringify(value)}) doesn't match the bundling option \`${name}\` (${JSON.stringify(bundlingValue)}), set only one of them`);
+  }
+  return value ?? bundlingValue;
+}
+
+/**
+ * Reject the options that activate all the features together with a list of features,
+ * Cargo Lambda would only activate all the features.
+ */
+export function validateFeatures(options: { features?: string[]; allFeatures?: boolean }, source = 'the options') {
+  if (options.allFeatures && options.features && options.features.length > 0) {
+    throw new Error(`${source} \`allFeatures\` and \`features\` cannot be used together, \`allFeatures\` already activates the features ${options.features.join(', ')}`);
+  }
+}
+
+/**
+ * The prebuilt artifact in the bundling options, when the props don't point to the Cargo sources.
+ * The artifact is deployed without reading the sources, so they don't need to exist, i.e. in a promotion stage.
+ */
+export function prebuiltArtifactWithoutSources(
+  props: RustFunctionProps | RustExtensionProps | undefined,
+  bundling: BundlingOptions,
+): string | undefined {
+  const sources = props?.manifestPath ?? props?.gitRemote ?? props?.sourceArchive;
+  return sources === undefined ? bundling.prebuiltArtifact : undefined;
+}
+
+export function architectureFromWorkspace(workspace: RustWorkspace, props: RustFunctionProps): lambda.Architecture {
+  const functionArchitecture = props.bundling?.architecture ?? props.architecture;
+  if (functionArchitecture && functionArchitecture.name !== workspace.architecture.name) {
+    throw new Error(
+      `Architecture mismatch: the architecture of the workspace (${workspace.architecture.name}) didn't match the architecture of the underlying lambda (${functionArchitecture.name}).`,
+    );
+  }
+  return workspace.architecture;
+}
+
+export function runtimeFromWorkspace(workspace: RustWorkspace, props: RustFunctionProps): RustRuntime {
+  if (props.runtime && props.runtime !== workspace.runtime) {
+    throw new Error(
+      `Runtime mismatch: the binaries of the workspace are built for ${workspace.runtime}, but the runtime of the function is ${props.runtime}, set the option \`runtime\` of the workspace`,
+    );
+  }
+  return workspace.runtime;
+}
+
+export function binaryNameFromWorkspaceProps(props: RustFunctionProps): string {
+  if (!props.binaryName) {
+    throw new Error('the function is built by a workspace, use the option `binaryName` to specify the binary to build');
+  }
+  return props.binaryName;
+}
+
+/**
+ * Apply the CDK context to the bundling options: the build variant and the build cache directory.
+ */
+export function bundlingOptionsWithContext(scope: Construct, bundling: BundlingOptions): BundlingOptions {
+  const options = bundlingOptionsWithVariant(scope, bundling);
+  const buildCache = buildCacheOptions(scope, options.buildCache);
+  return buildCache ? { ...options, buildCache } : options;
+}
+
+/**
+ * Merge the build variant selected by the CDK context into the bundling options.
+ */
+export function bundlingOptionsWithVariant(scope: Construct, bundling: BundlingOptions): BundlingOptions {
+  if (!bundling.variants) {
+    return bundling;
+  }
+
+  const contextKey = bundling.variantContextKey ?? 'stage';
+  const stage = scope.node.tryGetContext(contextKey);
+  if (stage === undefined) {
+    return bundling;
+  }
+
+  const variant = bundling.variants[stage];
+  if (!variant) {
+    throw new Error(`there is no build variant for the stage \`${stage}\` selected by the context key \`${contextKey}\`, available variants: ${Object.keys(bundling.variants).join(', ')}`);
+  }
+
+  const options = {
+    ...bundling,
+    features: variant.features ?? bundling.features,
+    noDefaultFeatures: variant.noDefaultFeatures ?? bundling.noDefaultFeatures,
+    allFeatures: variant.allFeatures ?? bundling.allFeatures,
+    profile: variant.profile ?? bundling.profile,
+  };
+  validateFeatures(options, `with the build variant \`${stage}\`, the options`);
+  return options;
+}
+
+/**
+ * Compare two dotted versions, i.e. `2.9` is older than `2.17`.
+ */
+export function compareVersions(a: string, b: string): number {
+  const left = a.split('.').map(Number);
+  const right = b.split('.').map(Number);
+  for (let i = 0; i < Math.max(left.length, right.length); i++) {
+    const diff = (left[i] ?? 0) - (right[i] ?? 0);
+    if (diff !== 0) {
+      return diff;
+    }
+  }
+  return 0;
+}
@@ -0,0 +1,169 @@
+import { readdirSync, readFileSync, statSync } from 'node:fs';
+import { join, posix, relative, sep } from 'node:path';
+import { deflateRawSync } from 'node:zlib';
+import { Annotations } from 'aws-cdk-lib';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { Construct } from 'constructs';
+import { readZipEntries } from './artifact';
+import { elfArchitecture, glibcVersions, readElfHeader } from './elf';
+import { RUNTIME_GLIBC_VERSIONS } from './target';
+import { VerificationSeverity } from './types';
+import { compareVersions } from './util';
+
+const MAX_ZIPPED_SIZE = 50 * 1024 * 1024;
+const MAX_UNZIPPED_SIZE = 250 * 1024 * 1024;
+
+/**
+ * What to verify in a bundle.
+ */
+export interface BundleVerificationOptions {
+  /**
+   * The architecture that the binaries must be built for.
+   */
+  readonly architecture?: Architecture;
+
+  /**
+   * The Lambda runtime that runs the binaries, it sets the newest GLIBC version that they can require.
+   */
+  readonly runtime?: string;
+
+  /**
+   * Whether the bundle contains an extension in the `extensions` directory, instead of the `bootstrap` binary.
+   */
+  readonly lambdaExtension?: boolean;
+
+  /**
+   * The binaries in the bundle, each one in a directory with the binary's name.
+   */
+  readonly binaryNames?: string[];
+
+  /**
+   * Whether the bundle is packaged in a container image, which doesn't have the size limits of zip packages.
+   */
+  readonly containerImage?: boolean;
+}
+
+interface BundleFile {
+  readonly name: string;
+  readonly size: number;
+  readonly compressedSize: () => number;
+  readonly read: () => Buffer;
+}
+
+/**
+ * Check the binaries in a bundle directory, or zip file, and return the problems found.
+ */
+export function verifyBundle(bundlePath: string, options: BundleVerificationOptions): string[] {
+  const findings: string[] = [];
+  const packages = options.binaryNames
+    ? options.binaryNames.map(binaryName => ({ prefix: `${binaryName}: `, path: join(bundlePath, binaryName) }))
+    : [{ prefix: '', path: bundlePath }];
+
+  for (const pkg of packages) {
+    const files = bundleFiles(pkg.path);
+    const binaries = options.lambdaExtension
+      ? files.filter(file => file.name.startsWith('extensions/'))
+      : files.filter(file => file.name === 'bootstrap');
+
+    if (binaries.length === 0) {
+      findings.push(`${pkg.prefix}the bundle doesn't contain ${options.lambdaExtension ? 'an extension in `extensions`' : 'the `bootstrap` binary'}`);
+    }
+    for (const binary of binaries) {
+      findings.push(...verifyBinary(binary, options).map(finding => `${pkg.prefix}${finding}`));
+    }
+
+    if (!options.containerImage) {
+      const unzipped = files.reduce((total, file) => total + file.size, 0);
+      if (unzipped > MAX_UNZIPPED_SIZE) {
+        findings.push(`${pkg.prefix}the bundle is ${megabytes(unzipped)} MB unzipped, over the Lambda limit of ${megabytes(MAX_UNZIPPED_SIZE)} MB`);
+      }
+      // compressing the files is slow, and the bundle can only be over the zipped limit when it's over it unzipped
+      const zipped = unzipped > MAX_ZIPPED_SIZE ? files.reduce((total, file) => total + file.compressedSize(), 0) : 0;
+      if (zipped > MAX_ZIPPED_SIZE) {
+        findings.push(`${pkg.prefix}the bundle is ${megabytes(zipped)} MB zipped, over the Lambda limit of ${megabytes(MAX_ZIPPED_SIZE)} MB`);
+      }
+    }
+  }
+
+  return findings;
+}
+
+function verifyBinary(binary: BundleFile, options: BundleVerificationOptions): string[] {
+  const data = binary.read();
+  const header = readElfHeader(data);
+  if (!header) {
+    return [`\`${binary.name}\` is not an ELF binary`];
+  }
+
+  const findings: string[] = [];
+  const expected = options.architecture ?? Architecture.X86_64;
+  const actual = elfArchitecture(header);
+  if (actual?.name !== expected.name) {
+    findings.push(`\`${binary.name}\` was built for ${actual?.name ?? `the ELF machine ${header.machine}`}, but the architecture is ${expected.name}`);
+  }
+
+  const runtimeGlibc = options.runtime ? RUNTIME_GLIBC_VERSIONS[options.runtime] : undefined;
+  const required = glibcVersions(data).pop();
+  if (runtimeGlibc && required && compareVersions(required, runtimeGlibc) > 0) {
+    findings.push(`\`${binary.name}\` requires GLIBC ${required}, but the runtime ${options.runtime} provides GLIBC ${runtimeGlibc}`);
+  }
+
+  return findings;
+}
+
+/**
+ * Report the problems found as warnings, or fail the synthesis with them, depending on the severity.
+ */
+export function reportFindings(scope: Construct, findings: string[], severity?: VerificationSeverity) {
+  if (findings.length === 0 || severity === VerificationSeverity.IGNORE) {
+    return;
+  }
+
+  if (severity !== VerificationSeverity.ERROR) {
+    for (const finding of findings) {
+      Annotations.of(scope).addWarningV2('cargo-lambda-cdk:bundleVerification', finding);
+    }
+    return;
+  }
+
+  throw new Error(`the bundle verification failed: ${findings.join(', ')}`);
+}
+
+function bundleFiles(path: string): BundleFile[] {
+  if (statSync(path).isFile()) {
+    return zipFiles(path);
+  }
+
+  // Cargo Lambda's zip output format produces a single zip file
+  const entries = readdirSync(path);
+  if (entries.length === 1 && entries[0].endsWith('.zip')) {
+    return zipFiles(join(path, entries[0]));
+  }
+
+  return listFiles(path).map(file => ({
+    name: relative(path, file).split(sep).join(posix.sep),
+    size: statSync(file).size,
+    compressedSize: () => deflateRawSync(readFileSync(file)).length,
+    read: () => readFileSync(file),
+  }));
+}
+
+function zipFiles(path: string): BundleFile[] {
+  return readZipEntries(path).filter(entry => !entry.name.endsWith('/')).map(entry => ({
+    name: entry.name,
+    size: entry.size,
+    compressedSize: () => entry.compressedSize,
+    read: entry.read,
+  }));
+}
+
+function listFiles(dir: string): string[] {
+  return readdirSync(dir).flatMap(entry => {
+    const path = join(dir, entry);
+    return statSync(path).isDirectory() ? listFiles(path) : [path];
+  });
+}
+
+function megabytes(bytes: number): number {
+  return Math.round(bytes / 1024 / 1024);
+}
@@ -0,0 +1,163 @@
+import { createHash } from 'node:crypto';
+import { existsSync, mkdirSync, rmSync } from 'node:fs';
+import { platform, userInfo } from 'node:os';
+import { join, resolve } from 'node:path';
+import { DockerVolume } from 'aws-cdk-lib';
+import { DockerCacheOptions } from './types';
+import { exec } from './util';
+
+const DEFAULT_VOLUME_PREFIX = 'cargo-lambda-cdk';
+// the CARGO_HOME of the default image
+const DEFAULT_CARGO_HOME = '/usr/local/cargo';
+const CONTAINER_TARGET_DIR = '/cargo-lambda-cdk/target';
+
+/**
+ * Options to remove the Docker build caches.
+ */
+export interface DockerCachePruneOptions {
+  /**
+   * The prefix of the Docker volumes to remove.
+   *
+   * @default cargo-lambda-cdk
+   */
+  readonly volumePrefix?: string;
+
+  /**
+   * Remove the cache directories in this host directory, instead of the Docker volumes.
+   *
+   * @default - remove the Docker volumes
+   */
+  readonly hostDirectory?: string;
+
+  /**
+   * Keep the crates downloaded from the registries and git repositories,
+   * and only remove the target directories.
+   *
+   * @default false
+   */
+  readonly keepDownloads?: boolean;
+}
+
+/**
+ * The Docker build caches for the Cargo downloads and the target directories.
+ */
+export class DockerCache {
+  /**
+   * Remove the Docker volumes, or the host directories, that cache the Cargo downloads
+   * and the target directories, and return the names of the removed volumes or directories.
+   *
+   * Volumes used by a running container are not removed.
+   */
+  public static prune(options: DockerCachePruneOptions = {}): string[] {
+    if (options.hostDirectory) {
+      const dirs = [join(resolve(options.hostDirectory), 'target')];
+      if (!options.keepDownloads) {
+        dirs.push(join(resolve(options.hostDirectory), 'cargo-home'));
+      }
+
+      const removed = dirs.filter(dir => existsSync(dir));
+      removed.forEach(dir => rmSync(dir, { recursive: true, force: true }));
+      return removed;
+    }
+
+    const docker = process.env.CDK_DOCKER ?? 'docker';
+    const prefix = `${options.volumePrefix ?? DEFAULT_VOLUME_PREFIX}-`;
+    const volumes = exec(docker, ['volume', 'ls', '--quiet', '--filter', `name=${prefix}`]).stdout.toString()
+      .split('\n')
+      .map(volume => volume.trim())
+      .filter(volume => volume.startsWith(prefix))
+      .filter(volume => !options.keepDownloads || volume.startsWith(`${prefix}target-`));
+
+    const removed: string[] = [];
+    for (const volume of volumes) {
+      try {
+        exec(docker, ['volume', 'rm', volume]);
+        removed.push(volume);
+      } catch (err) {
+        // the volume is in use
+      }
+    }
+    return removed;
+  }
+}
+
+/**
+ * The volumes and the environment to cache the Cargo downloads and the target directory in Docker builds.
+ */
+export interface DockerCacheMounts {
+  readonly volumes: DockerVolume[];
+  readonly environment: { [key: string]: string };
+
+  /**
+   * Create the cache directories, and give the volumes to the user that runs the build.
+   */
+  prepare(image: string, user?: string): void;
+}
+
+// the volumes that already belong to the user, so they're only prepared once per synthesis
+const preparedVolumes = new Set<string>();
+
+/**
+ * The Docker build cache for a project, the downloads are shared with all the projects,
+ * and each project has its own target directory.
+ */
+export function dockerCacheMounts(projectRoot: string, options?: DockerCacheOptions): DockerCacheMounts | undefined {
+  if (!options) {
+    return undefined;
+  }
+
+  const projectKey = createHash('sha256').update(resolve(projectRoot)).digest('hex').slice(0, 12);
+  // only the downloads are mounted in the image's CARGO_HOME, so its configuration and credentials still apply
+  const cargoHome = (options.cargoHome ?? DEFAULT_CARGO_HOME).replace(/\/+$/, '');
+  const environment = {
+    CARGO_TARGET_DIR: CONTAINER_TARGET_DIR,
+  };
+
+  if (options.hostDirectory) {
+    // the directories are created by the current user, so they already belong to the user in the container
+    const hostCargoHome = join(resolve(options.hostDirectory), 'cargo-home');
+    const volumes = [
+      { hostPath: join(hostCargoHome, 'registry'), containerPath: `${cargoHome}/registry` },
+      { hostPath: join(hostCargoHome, 'git'), containerPath: `${cargoHome}/git` },
+      { hostPath: join(resolve(options.hostDirectory), 'target', projectKey), containerPath: CONTAINER_TARGET_DIR },
+    ];
+    return {
+      volumes,
+      environment,
+      prepare() {
+        volumes.forEach(volume => mkdirSync(volume.hostPath, { recursive: true }));
+      },
+    };
+  }
+
+  const prefix = options.volumePrefix ?? DEFAULT_VOLUME_PREFIX;
+  const volumes = [
+    { hostPath: `${prefix}-registry`, containerPath: `${cargoHome}/registry` },
+    { hostPath: `${prefix}-git`, containerPath: `${cargoHome}/git` },
+    { hostPath: `${prefix}-target-${projectKey}`, containerPath: CONTAINER_TARGET_DIR },
+  ];
+
+  return {
+    volumes,
+    environment,
+    prepare(image: string, user?: string) {
+      // Docker creates the volumes owned by root, but the build runs with the user's uid and gid
+      const owner = user ?? (platform() === 'win32' ? '1000:1000' : `${userInfo().uid}:${userInfo().gid}`);
+      const key = `${image}|${owner}|${volumes.map(volume => volume.hostPath).join(',')}`;
+      if (preparedVolumes.has(key)) {
+        return;
+      }
+
+      exec(process.env.CDK_DOCKER ?? 'docker', [
+        'run', '--rm',
+        '--user', '0:0',
+        '--entrypoint', 'chown',
+        ...volumes.flatMap(volume => ['--volume', `${volume.hostPath}:${volume.containerPath}`]),
+        image,
+        owner,
+        ...volumes.map(volume => volume.containerPath),
+      ]);
+      preparedVolumes.add(key);
+    },
+  };
+}
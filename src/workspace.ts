@@ -0,0 +1,243 @@
+import { createHash } from 'node:crypto';
+import { cpSync, mkdirSync } from 'node:fs';
+import { join, resolve } from 'node:path';
+import { tmpdir } from 'os';
+import { AssetHashType, AssetStaging, CfnResource, Stack, Stage } from 'aws-cdk-lib';
+import { Architecture, AssetCode, Code, CodeConfig, ResourceBindOptions } from 'aws-cdk-lib/aws-lambda';
+import { Asset } from 'aws-cdk-lib/aws-s3-assets';
+import { Construct } from 'constructs';
+import { Bundling, BundlingProps } from './bundling';
+import { getCargoProject, getCargoSource } from './cargo';
+import { resolveBuildConfig } from './config';
+import { PrebuildQueue } from './prebuild';
+import { BuildSetting, BundlingOptions, GitOptions, GitProvenance, RustRuntime } from './types';
+import { bundlingOptionsWithContext } from './util';
+
+/**
+ * Properties for a RustWorkspace
+ */
+export interface RustWorkspaceProps {
+  /**
+   * The names of the binaries to build.
+   *
+   * All these binaries are built with a single `cargo lambda build` command,
+   * the first time that a function requests the code for one of them.
+   */
+  readonly binaries: string[];
+
+  /**
+   * Bundling options shared by all the binaries in the workspace.
+   *
+   * @default - use default bundling options
+   */
+  readonly bundling?: BundlingOptions;
+
+  /**
+   * The Lambda runtime of the functions built by this workspace. The runtime selects the GLIBC
+   * version that the binaries are built for, so all the functions must use it.
+   *
+   * @default RustRuntime.PROVIDED_AL2023
+   */
+  readonly runtime?: RustRuntime;
+
+  /**
+   * Path to a directory containing your Cargo.toml file, or to your Cargo.toml directly.
+   *
+   * This will accept a directory path containing a `Cargo.toml` file (i.e. `path/to/workspace`), or a filepath to your
+   * `Cargo.toml` file (i.e. `path/to/Cargo.toml`). When the `gitRemote` option is provided,
+   * the `manifestPath` is relative to the root of the git repository. When the `sourceArchive`
+   * option is provided, it's relative to the root of the archive.
+   *
+   * @default - check the current directory for a `Cargo.toml` file, and throws
+   *  an error if the file doesn't exist.
+   */
+  readonly manifestPath?: string;
+
+  /**
+   * Path to a `.tar.gz`, `.tgz`, `.tar`, `.zip` or `.crate` archive with the source code,
+   * i.e. the output of `cargo package`.
+   *
+   * The archive is extracted into a temporary directory named after the archive's content.
+   * When the archive contains a single directory, like `.crate` files, that directory
+   * is the root of the archive.
+   *
+   * @default - the source code is not in an archive
+   */
+  readonly sourceArchive?: string;
+
+  /**
+   * The git remote URL to clone (e.g `https://github.com/your_user/your_repo`).
+   *
+   * This repository will be cloned to a temporary directory using `git`.
+   * The `git` command must be available in the PATH.
+   */
+  readonly gitRemote?: string;
+
+  /**
+   * The git reference to checkout. This can be a branch, tag, or commit hash.
+   *
+   * Only the commit that the reference points to is fetched, without the history of the repository.
+   *
+   * @default - the default branch, i.e. HEAD.
+   */
+  readonly gitReference?: string;
+
+  /**
+   * Always clone the repository if using the `gitRemote` option, even if it has already been
+   * cloned to the temporary directory.
+   *
+   * @default - clones only if the repository and reference don't already exist in the
+   * temporary directory.
+   */
+  readonly gitForceClone?: boolean;
+
+  /**
+   * Authentication and checkout options for the `gitRemote` option.
+   *
+   * @default - clone with the credentials in the environment, without submodules
+   */
+  readonly gitOptions?: GitOptions;
+}
+
+/**
+ * A Cargo workspace that builds the binaries for several Rust functions at once
+ */
+export class RustWorkspace extends Construct {
+  /**
+   * The system architecture that the binaries are built for.
+   */
+  public readonly architecture: Architecture;
+
+  /**
+   * The Lambda runtime that the binaries are built for.
+   */
+  public readonly runtime: RustRuntime;
+
+  /**
+   * The names of the binaries built by this workspace.
+   */
+  public readonly binaries: string[];
+
+  /**
+   * The build settings resolved from the bundling options and the Cargo Lambda build configuration.
+   */
+  public readonly buildSettings: BuildSetting[];
+
+  /**
+   * The git commit that the workspace was built from, when the source code is in a git remote.
+   * The functions built by this workspace record it as their provenance.
+   */
+  public readonly gitProvenance?: GitProvenance;
+
+  private readonly manifestPath: string;
+  private readonly bundling: Omit<BundlingProps, 'manifestPath'>;
+  private staging?: AssetStaging;
+
+  constructor(scope: Construct, id: string, props: RustWorkspaceProps) {
+    super(scope, id);
+
+    if (props.binaries.length === 0) {
+      throw new Error('the workspace doesn\'t include any binaries, use the option `binaries` to specify the binaries to build');
+    }
+
+    const source = getCargoSource(props, this);
+    this.manifestPath = source.manifestPath;
+    this.gitProvenance = source.gitProvenance;
+    this.binaries = props.binaries;
+
+    // Cargo Lambda writes each binary in a directory named after it
+    const project = getCargoProject(this.manifestPath);
+    for (const binaryName of this.binaries) {
+      const packages = project.packages.filter(pkg => pkg.binaries.includes(binaryName)).map(pkg => pkg.name);
+      if (packages.length > 1) {
+        throw new Error(`the binary \`${binaryName}\` is declared by the packages ${packages.join(', ')}, a workspace can only build binaries with different names, use a RustFunction with the option \`packageName\` instead`);
+      }
+    }
+    this.architecture = props.bundling?.architecture ?? Architecture.X86_64;
+    this.runtime = props.runtime ?? RustRuntime.PROVIDED_AL2023;
+    const buildConfig = resolveBuildConfig(this.manifestPath, bundlingOptionsWithContext(this, {
+      ...props.bundling,
+      architecture: this.architecture,
+    }));
+    this.bundling = buildConfig.bundling;
+    this.buildSettings = buildConfig.settings;
+  }
+
+  /**
+   * The Lambda code for one of the binaries in the workspace.
+   *
+   * The first call to this method builds all the binaries in the workspace.
+   * Each binary gets its own asset, so a change in one of them only
+   * updates the functions that use it.
+   */
+  public binaryCode(binaryName: string): AssetCode {
+    if (!this.binaries.includes(binaryName)) {
+      throw new Error(`the binary \`${binaryName}\` is not part of the workspace binaries: ${this.binaries.join(', ')}`);
+    }
+
+    if (!this.staging) {
+      this.staging = Bundling.stage(this, 'Bundle', {
+        ...this.bundling,
+        manifestPath: this.manifestPath,
+        binaryNames: this.binaries,
+        runtime: this.runtime,
+      });
+    }
+
+    if (!Stack.of(this).bundlingRequired) {
+      // the staged path is the source directory, so the binaries are replaced with an empty directory
+      const placeholder = join(Stage.of(this)?.assetOutdir ?? tmpdir(), 'cargo-lambda-cdk-skipped');
+      mkdirSync(placeholder, { recursive: true });
+      return Code.fromAsset(placeholder);
+    }
+
+    // the binaries of a deferred build are hashed with the workspace, so the hash doesn't depend on the build
+    if (Bundling.isDeferred(this.staging)) {
+      return new PendingBinaryCode(this.staging, binaryName);
+    }
+    return Code.fromAsset(join(this.staging.absoluteStagedPath, binaryName));
+  }
+}
+
+/**
+ * The code of a binary of a workspace that is built in the prebuild phase. The asset is hashed
+ * with the workspace fingerprint, and the binary is copied in it once the workspace is built.
+ */
+class PendingBinaryCode extends AssetCode {
+  private asset?: Asset;
+
+  constructor(private readonly staging: AssetStaging, private readonly binaryName: string) {
+    super(PrebuildQueue.placeholder(Stage.of(staging)?.assetOutdir ?? tmpdir()));
+  }
+
+  public bind(scope: Construct): CodeConfig {
+    if (!this.asset) {
+      this.asset = new Asset(scope, 'Code', {
+        path: this.path,
+        deployTime: true,
+        assetHashType: AssetHashType.CUSTOM,
+        assetHash: createHash('sha256').update(this.staging.assetHash).update(this.binaryName).digest('hex'),
+      });
+
+      const stagedPath = resolve(Stage.of(scope)?.outdir ?? '', this.asset.assetPath);
+      const output = join(this.staging.absoluteStagedPath, this.binaryName);
+      PrebuildQueue.of(scope)?.addCopy(this.staging.absoluteStagedPath, stagedPath, () => cpSync(output, stagedPath, { recursive: true }));
+    } else if (Stack.of(this.asset) !== Stack.of(scope)) {
+      throw new Error(`the code of the binary \`${this.binaryName}\` is already used in the stack \`${Stack.of(this.asset).node.path}\`, get a new code from the workspace for each stack`);
+    }
+
+    return {
+      s3Location: {
+        bucketName: this.asset.s3BucketName,
+        objectKey: this.asset.s3ObjectKey,
+      },
+    };
+  }
+
+  public bindToResource(resource: CfnResource, options: ResourceBindOptions = {}) {
+    if (!this.asset) {
+      throw new Error('bindToResource() must be called after bind()');
+    }
+    this.asset.addResourceMetadata(resource, options.resourceProperty ?? 'Code');
+  }
+}
@@ -0,0 +1,83 @@
+import { existsSync, lstatSync, readFileSync, readlinkSync, statSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { basename, dirname, join } from 'node:path';
+import { extractSourceArchive } from '../src/archive';
+import { getCargoSource } from '../src/cargo';
+
+const fixtures = join(__dirname, 'fixtures/archives');
+
+describe('extractSourceArchive', () => {
+  it('extracts crate files into the directory of the package', () => {
+    const root = extractSourceArchive(join(fixtures, 'simple-package-0.1.0.crate'));
+    expect(basename(root)).toEqual('simple-package-0.1.0');
+    expect(dirname(dirname(dirname(root)))).toEqual(tmpdir());
+    expect(readFileSync(join(root, 'src/main.rs')).toString()).toEqual(
+      readFileSync(join(__dirname, 'fixtures/single-package/src/main.rs')).toString(),
+    );
+  });
+
+  it('extracts zip files', () => {
+    const root = extractSourceArchive(join(fixtures, 'simple-package.zip'));
+    expect(existsSync(join(root, 'Cargo.toml'))).toBe(true);
+    expect(existsSync(join(root, 'src/main.rs'))).toBe(true);
+  });
+
+  it('extracts the symbolic links of zip files', () => {
+    const root = extractSourceArchive(join(fixtures, 'zip-symlink.zip'));
+    expect(lstatSync(join(root, 'main.rs')).isSymbolicLink()).toBe(true);
+    expect(readlinkSync(join(root, 'main.rs'))).toEqual('src/main.rs');
+    expect(() => extractSourceArchive(join(fixtures, 'zip-symlink-outside.zip'))).toThrow('contains the path `../../etc` outside the archive root');
+  });
+
+  it('extracts into a directory that only the current user can access', () => {
+    const root = extractSourceArchive(join(fixtures, 'simple-package.zip'));
+    const stat = statSync(dirname(root));
+    expect(stat.uid).toEqual(process.getuid!());
+    expect(stat.mode % 0o100).toEqual(0);
+  });
+
+  it('names the directory after the content of the archive', () => {
+    const archive = join(fixtures, 'simple-package.zip');
+    expect(extractSourceArchive(archive)).toEqual(extractSourceArchive(archive));
+    expect(extractSourceArchive(archive)).not.toEqual(dirname(extractSourceArchive(join(fixtures, 'simple-package-0.1.0.crate'))));
+  });
+
+  it('fails with paths outside the archive', () => {
+    expect(() => extractSourceArchive(join(fixtures, 'path-traversal.tar.gz'))).toThrow(
+      'contains the path `../outside.txt` outside the archive root',
+    );
+  });
+
+  it('fails with paths through symbolic links', () => {
+    // p/q/a -> ../.. and p/q/a/l -> ../.. are inside the root, but p/q/a/l/x is not
+    expect(() => extractSourceArchive(join(fixtures, 'symlink-chain.tar.gz'))).toThrow(
+      'contains the path `p/q/a/l` through the symbolic link `p/q/a`',
+    );
+  });
+
+  it('fails with symbolic links that resolve outside the root', () => {
+    // m/L -> d/../.. is inside the root until m/d -> .. is created after it
+    expect(() => extractSourceArchive(join(fixtures, 'symlink-later.tar.gz'))).toThrow(
+      'contains the symbolic link `m/L` to a path outside the archive root',
+    );
+  });
+
+  it('fails with unsupported files', () => {
+    expect(() => extractSourceArchive(join(__dirname, 'fixtures/single-package/Cargo.toml'))).toThrow('is not supported');
+    expect(() => extractSourceArchive(join(fixtures, 'missing.crate'))).toThrow('doesn\'t exist');
+  });
+});
+
+describe('getCargoSource', () => {
+  it('finds the manifest in the archive', () => {
+    const source = getCargoSource({ sourceArchive: join(fixtures, 'simple-package-0.1.0.crate') });
+    expect(basename(dirname(source.manifestPath))).toEqual('simple-package-0.1.0');
+    expect(source.gitProvenance).toBeUndefined();
+  });
+
+  it('fails with a git remote', () => {
+    expect(() => getCargoSource({ sourceArchive: join(fixtures, 'simple-package.zip'), gitRemote: 'https://github.com/your_user/your_repo' })).toThrow(
+      'the options `sourceArchive` and `gitRemote` cannot be used together',
+    );
+  });
+});
@@ -0,0 +1,49 @@
+import { join } from 'node:path';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { findPrebuiltArtifact, verifyPrebuiltArtifact } from '../src/artifact';
+
+const fixtures = join(__dirname, 'fixtures/prebuilt');
+
+describe('findPrebuiltArtifact', () => {
+  it('finds the binary in the Cargo Lambda output directory', () => {
+    expect(findPrebuiltArtifact(join(fixtures, 'x86-64'), 'simple-package')).toEqual(join(fixtures, 'x86-64/simple-package'));
+    expect(findPrebuiltArtifact(join(fixtures, 'x86-64/simple-package'), 'simple-package')).toEqual(join(fixtures, 'x86-64/simple-package'));
+  });
+
+  it('finds extensions and zip files', () => {
+    expect(findPrebuiltArtifact(join(fixtures, 'arm64'), 'log-extension', true)).toEqual(join(fixtures, 'arm64'));
+    expect(findPrebuiltArtifact(join(fixtures, 'bootstrap.zip'), 'simple-package')).toEqual(join(fixtures, 'bootstrap.zip'));
+  });
+
+  it('fails without the binary', () => {
+    expect(() => findPrebuiltArtifact(join(fixtures, 'missing'), 'simple-package')).toThrow(
+      'run `cargo lambda build` before the synthesis',
+    );
+    expect(() => findPrebuiltArtifact(join(fixtures, 'x86-64'), 'other-package')).toThrow(
+      'doesn\'t contain `bootstrap` for the binary `other-package`',
+    );
+    expect(() => findPrebuiltArtifact(join(fixtures, 'x86-64'), undefined)).toThrow(
+      'set the option `binaryName` to find the binary in the Cargo Lambda output directory',
+    );
+  });
+});
+
+describe('verifyPrebuiltArtifact', () => {
+  it('accepts binaries built for the architecture', () => {
+    verifyPrebuiltArtifact(join(fixtures, 'x86-64/simple-package'), 'simple-package');
+    verifyPrebuiltArtifact(join(fixtures, 'arm64'), 'log-extension', true, Architecture.ARM_64);
+    verifyPrebuiltArtifact(join(fixtures, 'bootstrap.zip'), 'simple-package', false, Architecture.ARM_64);
+  });
+
+  it('fails with binaries built for a different architecture', () => {
+    expect(() => verifyPrebuiltArtifact(join(fixtures, 'bootstrap.zip'), 'simple-package', false, Architecture.X86_64)).toThrow(
+      'was built for arm64, but the architecture is x86_64',
+    );
+  });
+
+  it('fails with artifacts without the binary', () => {
+    expect(() => verifyPrebuiltArtifact(join(fixtures, 'arm64'), 'log-extension')).toThrow(
+      'doesn\'t contain `bootstrap`',
+    );
+  });
+});
@@ -0,0 +1,114 @@
+import { appendFileSync, cpSync, existsSync, mkdirSync, mkdtempSync, readFileSync, rmSync, utimesSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { App, Size } from 'aws-cdk-lib';
+import { Bundling } from '../src/bundling';
+import { BUILD_CACHE_CONTEXT, buildCacheOptions, restoreBuild, storeBuild } from '../src/buildcache';
+import { getManifestPath } from '../src/cargo';
+import { BuildCache } from '../src/index';
+
+const fingerprint = (n: number) => n.toString(16).padStart(64, '0');
+
+describe('BuildCache', () => {
+  let dir: string;
+  let directory: string;
+
+  const output = (name: string, size: number) => {
+    const outputDir = join(dir, name);
+    mkdirSync(outputDir, { recursive: true });
+    writeFileSync(join(outputDir, 'bootstrap'), Buffer.alloc(size));
+    return outputDir;
+  };
+
+  beforeEach(() => {
+    dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-build-cache-'));
+    directory = join(dir, 'cache');
+  });
+
+  afterEach(() => {
+    rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('restores a stored build', () => {
+    expect(restoreBuild({ directory }, fingerprint(1), join(dir, 'restored'))).toBe(false);
+
+    storeBuild({ directory }, fingerprint(1), output('build', 10));
+    expect(restoreBuild({ directory }, fingerprint(1), join(dir, 'restored'))).toBe(true);
+    expect(readFileSync(join(dir, 'restored', 'bootstrap')).length).toEqual(10);
+  });
+
+  it('stores a zip file in a directory', () => {
+    const zipFile = join(dir, 'bootstrap.zip');
+    writeFileSync(zipFile, 'zip');
+
+    storeBuild({ directory }, fingerprint(1), zipFile);
+    expect(existsSync(join(directory, fingerprint(1), 'bootstrap.zip'))).toBe(true);
+  });
+
+  it('lists and clears the builds', () => {
+    storeBuild({ directory }, fingerprint(1), output('first', 10));
+    storeBuild({ directory }, fingerprint(2), output('second', 20));
+    utimesSync(join(directory, fingerprint(1)), new Date(2000, 1, 1), new Date(2000, 1, 1));
+
+    expect(BuildCache.list(directory).map(entry => [entry.fingerprint, entry.size])).toEqual([
+      [fingerprint(2), 20],
+      [fingerprint(1), 10],
+    ]);
+
+    expect(BuildCache.clear(directory, [fingerprint(1)])).toEqual([fingerprint(1)]);
+    expect(BuildCache.clear(directory)).toEqual([fingerprint(2)]);
+    expect(BuildCache.list(directory)).toEqual([]);
+  });
+
+  it('removes the least recently used builds', () => {
+    const maxSize = Size.kibibytes(1);
+    storeBuild({ directory, maxSize }, fingerprint(1), output('first', 400));
+    storeBuild({ directory, maxSize }, fingerprint(2), output('second', 400));
+    utimesSync(join(directory, fingerprint(1)), new Date(2000, 1, 1), new Date(2000, 1, 1));
+    utimesSync(join(directory, fingerprint(2)), new Date(2000, 1, 2), new Date(2000, 1, 2));
+
+    // the first build is used again, so the second build is the least recently used
+    expect(restoreBuild({ directory }, fingerprint(1), join(dir, 'restored'))).toBe(true);
+    storeBuild({ directory, maxSize }, fingerprint(3), output('third', 400));
+
+    expect(BuildCache.list(directory).map(entry => entry.fingerprint).sort()).toEqual([fingerprint(1), fingerprint(3)]);
+  });
+
+  it('reads the directory from the context', () => {
+    const app = new App({ context: { [BUILD_CACHE_CONTEXT]: directory } });
+    expect(buildCacheOptions(app)).toEqual({ directory });
+    expect(buildCacheOptions(app, { directory: 'other' })).toEqual({ directory: 'other' });
+    expect(buildCacheOptions(new App())).toBeUndefined();
+  });
+
+  it('reuses a cached build without Docker', () => {
+    const manifestPath = getManifestPath({ manifestPath: join(__dirname, 'fixtures/single-package/Cargo.toml') });
+    const options = { manifestPath, forcedDockerBundling: true, buildCache: { directory } };
+    const code = Bundling.bundle(options);
+    const assetHash = (code as any).options.assetHash;
+    storeBuild({ directory }, assetHash, output('build', 10));
+
+    const outputDir = join(dir, 'output');
+    mkdirSync(outputDir);
+    expect((code as any).options.bundling.local.tryBundle(outputDir, {})).toBe(true);
+    expect(existsSync(join(outputDir, 'bootstrap'))).toBe(true);
+  });
+
+  it('doesn\'t reuse the build of a package with different sources', () => {
+    // two copies of the same package, that only differ in src/main.rs
+    const fixture = join(__dirname, 'fixtures/single-package');
+    cpSync(fixture, join(dir, 'first'), { recursive: true });
+    cpSync(fixture, join(dir, 'second'), { recursive: true });
+    appendFileSync(join(dir, 'second/src/main.rs'), '\n// changed\n');
+
+    const assetHash = (name: string) => (Bundling.bundle({
+      manifestPath: join(dir, name, 'Cargo.toml'),
+      forcedDockerBundling: true,
+      buildCache: { directory },
+    }) as any).options.assetHash;
+    storeBuild({ directory }, assetHash('first'), output('build', 10));
+
+    expect(assetHash('second')).not.toEqual(assetHash('first'));
+    expect(restoreBuild({ directory }, assetHash('second'), join(dir, 'restored'))).toBe(false);
+  });
+});
@@ -1,3 +1,5 @@
+import * as fs from 'fs';
+import * as os from 'os';
 import * as path from 'path';
 import { env } from 'process';
 import { App, Stack } from 'aws-cdk-lib';
@@ -6,8 +8,9 @@ import { Template } from 'aws-cdk-lib/assertions';
 import * as lambda from 'aws-cdk-lib/aws-lambda';
 import { Bundling } from '../src/bundling';
 import { getManifestPath } from '../src/cargo';
-import { cargoLambdaVersion, RustFunction, RustFunctionProps } from '../src/index';
-import { bundlingOptionsFromRustFunctionProps } from '../src/util';
+import { BundlingProbe } from '../src/probe';
+import { BundlingMode, cargoLambdaVersion, Compiler, RustFunction, RustFunctionProps, VerificationSeverity } from '../src/index';
+import { bundlingOptionsFromRustFunctionProps, bundlingOptionsWithVariant } from '../src/util';
 
 describe('bundlingOptionsFromRustFunctionProps', () => {
   describe('architecture', () => {
@@ -47,6 +50,61 @@ describe('bundlingOptionsFromRustFunctionProps', () => {
       );
     });
   });
+
+  describe('features', () => {
+    it('uses the features of the function props', () => {
+      const options = bundlingOptionsFromRustFunctionProps({ features: ['telemetry'], noDefaultFeatures: true, bundling: { profile: 'dev' } });
+      expect(options.features).toEqual(['telemetry']);
+      expect(options.noDefaultFeatures).toBe(true);
+      expect(options.profile).toEqual('dev');
+    });
+
+    it('fails when the function props and the bundling options have different features', () => {
+      expect(() => bundlingOptionsFromRustFunctionProps({ features: ['telemetry'], bundling: { features: ['mocks'] } })).toThrow(
+        'the option `features` of the function (["telemetry"]) doesn\'t match the bundling option `features` (["mocks"]), set only one of them',
+      );
+    });
+  });
+});
+
+describe('bundlingOptionsWithVariant', () => {
+  const variants = {
+    dev: { features: ['local-mocks'], profile: 'dev' },
+    prod: { features: ['telemetry'], noDefaultFeatures: true },
+  };
+
+  const stackWithContext = (context?: { [key: string]: string }) => new Stack(new App({ context }));
+
+  it('uses the bundling options without a stage', () => {
+    const bundling = bundlingOptionsWithVariant(stackWithContext(), { features: ['base'], variants });
+    expect(bundling.features).toEqual(['base']);
+    expect(bundling.profile).toBeUndefined();
+  });
+
+  it('uses the variant for the stage', () => {
+    const bundling = bundlingOptionsWithVariant(stackWithContext({ stage: 'prod' }), { profile: 'release', variants });
+    expect(bundling.features).toEqual(['telemetry']);
+    expect(bundling.noDefaultFeatures).toBe(true);
+    expect(bundling.profile).toEqual('release');
+  });
+
+  it('uses a custom context key', () => {
+    const bundling = bundlingOptionsWithVariant(stackWithContext({ env: 'dev' }), { variantContextKey: 'env', variants });
+    expect(bundling.features).toEqual(['local-mocks']);
+    expect(bundling.profile).toEqual('dev');
+  });
+
+  it('fails when the variant activates all the features and a list of features', () => {
+    expect(() => bundlingOptionsWithVariant(stackWithContext({ stage: 'all' }), { features: ['base'], variants: { all: { allFeatures: true } } })).toThrow(
+      'with the build variant `all`, the options `allFeatures` and `features` cannot be used together',
+    );
+  });
+
+  it('fails with an unknown stage', () => {
+    expect(() => bundlingOptionsWithVariant(stackWithContext({ stage: 'qa' }), { variants })).toThrow(
+      'there is no build variant for the stage `qa` selected by the context key `stage`, available variants: dev, prod',
+    );
+  });
 });
 
 // Integration tests
@@ -193,6 +251,81 @@ describe('bundlingOptionsOverrideDefaults', () => {
     expect((bundlingOptions as any).options.bundling.command).toContain(command);
   });
 
+  describe('Add Cargo Lambda Build with features', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      features: ['one', 'two'],
+      noDefaultFeatures: true,
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --features one,two --no-default-features --flatten simple-package';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Add Cargo Lambda Build with all features', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      allFeatures: true,
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --all-features --flatten simple-package';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Add Cargo Lambda Build with all features and features', () => {
+    expect(() => Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      allFeatures: true,
+      features: ['one'],
+    })).toThrow('the options `allFeatures` and `features` cannot be used together, `allFeatures` already activates the features one');
+  });
+
+  describe('Add Cargo Lambda Build with the features of the function', () => {
+    const fn = new RustFunction(new Stack(new App()), 'Function', {
+      manifestPath: getTestManifestPath(),
+      features: ['one'],
+      bundling: { forcedDockerBundling },
+    });
+    expect(fn.buildSettings.find(setting => setting.name === 'features')?.value).toEqual('one');
+  });
+
+  describe('Add Cargo Lambda Build with compiler', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      compiler: Compiler.CARGO_ZIGBUILD,
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --compiler cargo-zigbuild --flatten simple-package';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Add Cargo Lambda Build with automatic compiler', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      compiler: Compiler.AUTO,
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --flatten simple-package';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Add Cargo Lambda Build with cross in the default image', () => {
+    expect(() => Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      compiler: Compiler.CROSS,
+    })).toThrow('the compiler `cross` is not available in the default Docker image');
+  });
+
   describe('Add Cargo Lambda Build with release profile info', () => {
     const bundlingOptions = Bundling.bundle({
       manifestPath: getTestManifestPath(),
@@ -205,3 +338,422 @@ describe('bundlingOptionsOverrideDefaults', () => {
     expect((bundlingOptions as any).options.bundling.command).toContain(command);
   });
 });
+
+describe('bundlingWorkspaceMembers', () => {
+  describe('Mount the workspace root', () => {
+    const workspaceRoot = path.join(__dirname, 'fixtures/cargo-workspace');
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: path.join(workspaceRoot, 'binary1/Cargo.toml'),
+      forcedDockerBundling: true,
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --package binary1 --flatten binary1';
+
+    expect((bundlingOptions as any).path).toEqual(workspaceRoot);
+    expect((bundlingOptions as any).options.bundling.workingDirectory).toEqual('/asset-input/binary1');
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Mount path dependencies', () => {
+    const root = path.join(__dirname, 'fixtures/path-dependency');
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: path.join(root, 'function/Cargo.toml'),
+      forcedDockerBundling: true,
+    });
+
+    expect((bundlingOptions as any).path).toEqual(root);
+    expect((bundlingOptions as any).options.bundling.workingDirectory).toEqual('/asset-input/function');
+  });
+});
+
+describe('bundlingIncludes', () => {
+  const manifestPath = path.join(__dirname, 'fixtures/deploy-config/Cargo.toml');
+
+  describe('Copy the included files after the build', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath,
+      forcedDockerBundling: true,
+      include: [{ source: 'deploy.env', destination: 'config/.env' }],
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --flatten deploy-config'
+      + ' && mkdir -p "/asset-output/config" && cp -R "/asset-input/deploy.env" "/asset-output/config/.env"';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Include files in zip files with Cargo Lambda', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath,
+      forcedDockerBundling: true,
+      outputFormat: 'zip',
+      include: [{ source: 'deploy.env' }],
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --include deploy.env --output-format zip --flatten deploy-config';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+});
+
+describe('bundlingMode', () => {
+  describe('Conflict with forced Docker bundling', () => {
+    expect(() => Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      mode: BundlingMode.LOCAL,
+    })).toThrow('the option `forcedDockerBundling` conflicts with the bundling mode `local`');
+  });
+
+  describe('Copy the output of a previous build in prebuilt mode', () => {
+    const targetDir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+    fs.cpSync(path.join(__dirname, 'fixtures/prebuilt/x86-64'), path.join(targetDir, 'lambda'), { recursive: true });
+
+    const previous = env.CARGO_TARGET_DIR;
+    env.CARGO_TARGET_DIR = targetDir;
+    try {
+      const bundlingOptions = Bundling.bundle({
+        manifestPath: getTestManifestPath(),
+        mode: BundlingMode.PREBUILT,
+      });
+
+      const outputDir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+      expect((bundlingOptions as any).options.bundling.local.tryBundle(outputDir, {})).toEqual(true);
+      expect(fs.existsSync(path.join(outputDir, 'bootstrap'))).toEqual(true);
+
+      fs.rmSync(path.join(targetDir, 'lambda'), { recursive: true });
+      expect(() => (bundlingOptions as any).options.bundling.local.tryBundle(outputDir, {})).toThrow(
+        'run `cargo lambda build` before the synthesis',
+      );
+    } finally {
+      if (previous === undefined) {
+        delete env.CARGO_TARGET_DIR;
+      } else {
+        env.CARGO_TARGET_DIR = previous;
+      }
+    }
+  });
+});
+
+describe('bundlingPrebuiltArtifact', () => {
+  describe('Copy a prebuilt zip file', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      architecture: lambda.Architecture.ARM_64,
+      prebuiltArtifact: path.join(__dirname, 'fixtures/prebuilt/bootstrap.zip'),
+    });
+
+    const outputDir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+    expect((bundlingOptions as any).options.bundling.local.tryBundle(outputDir, {})).toEqual(true);
+    expect(fs.readdirSync(outputDir)).toEqual(['bootstrap.zip']);
+  });
+
+  describe('Fail with a binary for a different architecture', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      architecture: lambda.Architecture.X86_64,
+      prebuiltArtifact: path.join(__dirname, 'fixtures/prebuilt/bootstrap.zip'),
+    });
+
+    const outputDir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+    expect(() => (bundlingOptions as any).options.bundling.local.tryBundle(outputDir, {})).toThrow(
+      'was built for arm64, but the architecture is x86_64',
+    );
+  });
+
+  describe('Fail with a binary that several packages declare', () => {
+    expect(() => Bundling.bundle({
+      manifestPath: path.join(__dirname, 'fixtures/duplicate-binaries/a/Cargo.toml'),
+      mode: BundlingMode.PREBUILT,
+      binaryName: 'lambda',
+    })).toThrow('the binary `lambda` is declared by the packages a, b');
+  });
+
+  describe('Copy a prebuilt artifact without the Cargo sources', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: path.join(os.tmpdir(), 'missing/Cargo.toml'),
+      prebuiltArtifact: path.join(__dirname, 'fixtures/prebuilt/x86-64'),
+      binaryName: 'simple-package',
+    });
+
+    const outputDir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+    expect((bundlingOptions as any).options.bundling.local.tryBundle(outputDir, {})).toEqual(true);
+    expect(fs.readdirSync(outputDir)).toEqual(['bootstrap']);
+  });
+
+  describe('Conflict with other bundling modes', () => {
+    expect(() => Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      mode: BundlingMode.DOCKER,
+      prebuiltArtifact: path.join(__dirname, 'fixtures/prebuilt/bootstrap.zip'),
+    })).toThrow('the option `prebuiltArtifact` conflicts with the bundling mode `docker`');
+  });
+});
+
+describe('bundlingTarget', () => {
+  describe('Build for the GLIBC version of provided.al2', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      architecture: lambda.Architecture.ARM_64,
+      runtime: 'provided.al2',
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --target aarch64-unknown-linux-gnu.2.26 --flatten simple-package';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Build for an explicit musl target', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      runtime: 'provided.al2',
+      target: 'x86_64-unknown-linux-musl',
+    });
+
+    const command = 'cargo lambda build --lambda-dir /asset-output --release --target x86_64-unknown-linux-musl --flatten simple-package';
+
+    expect((bundlingOptions as any).options.bundling.command).toContain(command);
+  });
+
+  describe('Fail with a target for a different architecture', () => {
+    expect(() => Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      architecture: lambda.Architecture.ARM_64,
+      target: 'x86_64-unknown-linux-gnu',
+    })).toThrow('the target `x86_64-unknown-linux-gnu` doesn\'t match the architecture arm64');
+  });
+});
+
+describe('bundlingDockerCache', () => {
+  it('caches the downloads and the target directory in Docker volumes', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      environment: { CARGO_TERM_COLOR: 'never' },
+      dockerCache: {},
+    });
+
+    const bundling = (bundlingOptions as any).options.bundling;
+    // the image's CARGO_HOME is kept with its configuration
+    expect(bundling.environment).toEqual({
+      CARGO_TARGET_DIR: '/cargo-lambda-cdk/target',
+      CARGO_TERM_COLOR: 'never',
+    });
+    expect(bundling.volumes).toEqual([
+      { hostPath: 'cargo-lambda-cdk-registry', containerPath: '/usr/local/cargo/registry' },
+      { hostPath: 'cargo-lambda-cdk-git', containerPath: '/usr/local/cargo/git' },
+      { hostPath: expect.stringMatching(/^cargo-lambda-cdk-target-[0-9a-f]{12}$/), containerPath: '/cargo-lambda-cdk/target' },
+    ]);
+  });
+
+  it('mounts the downloads in the CARGO_HOME of the image', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      dockerCache: { cargoHome: '/opt/cargo/' },
+    });
+
+    expect((bundlingOptions as any).options.bundling.volumes.map((volume: cdk.DockerVolume) => volume.containerPath)).toEqual([
+      '/opt/cargo/registry',
+      '/opt/cargo/git',
+      '/cargo-lambda-cdk/target',
+    ]);
+  });
+
+  it('mounts the host directories with the volumes from the docker options', () => {
+    const hostDirectory = path.join(os.tmpdir(), 'cargo-lambda-cdk-cache');
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      dockerCache: { hostDirectory },
+      dockerOptions: {
+        volumes: [{ hostPath: '/etc/ssl/certs', containerPath: '/etc/ssl/certs' }],
+      },
+    });
+
+    expect((bundlingOptions as any).options.bundling.volumes).toEqual([
+      { hostPath: path.join(hostDirectory, 'cargo-home', 'registry'), containerPath: '/usr/local/cargo/registry' },
+      { hostPath: path.join(hostDirectory, 'cargo-home', 'git'), containerPath: '/usr/local/cargo/git' },
+      { hostPath: expect.stringMatching(new RegExp(`^${hostDirectory}/target/[0-9a-f]{12}$`)), containerPath: '/cargo-lambda-cdk/target' },
+      { hostPath: '/etc/ssl/certs', containerPath: '/etc/ssl/certs' },
+    ]);
+  });
+
+  it('starts from a clean container without the option', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+    });
+
+    const bundling = (bundlingOptions as any).options.bundling;
+    expect(bundling.environment).toBeUndefined();
+    expect(bundling.volumes).toBeUndefined();
+  });
+
+  it('doesn\'t mount the cache without Docker bundling', () => {
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      mode: BundlingMode.LOCAL,
+    });
+
+    expect((bundlingOptions as any).options.bundling.volumes).toBeUndefined();
+  });
+});
+
+describe('bundlingDockerCacheByDefault', () => {
+  it('keeps the volumes mounted in the default CARGO_HOME', () => {
+    const volumes = [{ hostPath: path.join(os.homedir(), '.cargo/registry'), containerPath: '/usr/local/cargo/registry' }];
+    const bundlingOptions = Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      dockerOptions: { volumes },
+    });
+
+    // the cache is only mounted when the option is set
+    const bundling = (bundlingOptions as any).options.bundling;
+    expect(bundling.volumes).toEqual(volumes);
+    expect(bundling.environment).toBeUndefined();
+  });
+});
+
+describe('bundlingFingerprint', () => {
+  const bundlingOptions = (options: any) => (Bundling.bundle({
+    manifestPath: getTestManifestPath(),
+    forcedDockerBundling: true,
+    ...options,
+  }) as any).options;
+
+  it('uses the build fingerprint as the asset hash', () => {
+    const options = bundlingOptions({});
+    expect(options.assetHashType).toEqual(cdk.AssetHashType.CUSTOM);
+    expect(options.assetHash).toMatch(/^[0-9a-f]{64}$/);
+    expect(bundlingOptions({}).assetHash).toEqual(options.assetHash);
+  });
+
+  it('changes the fingerprint with the build settings', () => {
+    const fingerprint = bundlingOptions({}).assetHash;
+    expect(bundlingOptions({ features: ['feature1'] }).assetHash).not.toEqual(fingerprint);
+    expect(bundlingOptions({ profile: 'dev' }).assetHash).not.toEqual(fingerprint);
+    expect(bundlingOptions({ architecture: lambda.Architecture.ARM_64 }).assetHash).not.toEqual(fingerprint);
+    expect(bundlingOptions({ environment: { RUSTFLAGS: '-C target-cpu=neoverse-n1' } }).assetHash).not.toEqual(fingerprint);
+  });
+
+  it('keeps the asset hash options', () => {
+    expect(bundlingOptions({ assetHashType: cdk.AssetHashType.OUTPUT }).assetHashType).toEqual(cdk.AssetHashType.OUTPUT);
+    expect(bundlingOptions({ assetHash: 'custom' }).assetHash).toEqual('custom');
+  });
+
+  it('is only calculated for the stacks that are bundled', () => {
+    const probe = jest.spyOn(BundlingProbe, 'run');
+    try {
+      const stack = new Stack(new App({ context: { 'aws:cdk:bundling-stacks': [] } }), 'Skipped');
+      Bundling.stage(stack, 'Bundle', { manifestPath: getTestManifestPath() });
+      new RustFunction(stack, 'Function', { manifestPath: getTestManifestPath() });
+      expect(probe).not.toHaveBeenCalled();
+    } finally {
+      probe.mockRestore();
+    }
+  });
+
+  it('hashes the output of prebuilt artifacts', () => {
+    const options = bundlingOptions({
+      forcedDockerBundling: false,
+      architecture: lambda.Architecture.ARM_64,
+      prebuiltArtifact: path.join(__dirname, 'fixtures/prebuilt/bootstrap.zip'),
+    });
+    expect(options.assetHashType).toEqual(cdk.AssetHashType.OUTPUT);
+    expect(options.assetHash).toBeUndefined();
+  });
+});
+
+describe('bundlingDeduplication', () => {
+  it('reuses an identical build of a different app', () => {
+    const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+    const options = {
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      profile: 'deduplication',
+      verification: VerificationSeverity.IGNORE,
+    };
+
+    // the first build is restored from the build cache, so it doesn't need Docker
+    const fingerprint = (Bundling.bundle(options) as any).options.assetHash;
+    fs.mkdirSync(path.join(dir, 'cache', fingerprint), { recursive: true });
+    fs.writeFileSync(path.join(dir, 'cache', fingerprint, 'bootstrap'), 'binary');
+    Bundling.stage(new Stack(new App({ outdir: path.join(dir, 'first') })), 'Bundle', {
+      ...options,
+      buildCache: { directory: path.join(dir, 'cache') },
+    });
+
+    const outputDir = path.join(dir, 'output');
+    fs.mkdirSync(outputDir);
+    expect((Bundling.bundle(options) as any).options.bundling.local.tryBundle(outputDir, {})).toEqual(true);
+    expect(fs.readFileSync(path.join(outputDir, 'bootstrap'), 'utf-8')).toEqual('binary');
+    fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('doesn\'t reuse the build of a package with different sources', () => {
+    const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'cargo-lambda-cdk-'));
+    // two copies of the same package, that only differ in src/main.rs
+    const fixture = path.dirname(getTestManifestPath());
+    fs.cpSync(fixture, path.join(dir, 'first'), { recursive: true });
+    fs.cpSync(fixture, path.join(dir, 'second'), { recursive: true });
+    fs.appendFileSync(path.join(dir, 'second/src/main.rs'), '\n// changed\n');
+    const options = (name: string) => ({
+      manifestPath: path.join(dir, name, 'Cargo.toml'),
+      forcedDockerBundling: true,
+      verification: VerificationSeverity.IGNORE,
+    });
+
+    const fingerprint = (Bundling.bundle(options('first')) as any).options.assetHash;
+    fs.mkdirSync(path.join(dir, 'cache', fingerprint), { recursive: true });
+    fs.writeFileSync(path.join(dir, 'cache', fingerprint, 'bootstrap'), 'binary');
+    Bundling.stage(new Stack(new App({ outdir: path.join(dir, 'cdk.out') })), 'Bundle', {
+      ...options('first'),
+      buildCache: { directory: path.join(dir, 'cache') },
+    });
+
+    // the second package runs its own Docker build
+    const capabilities = BundlingProbe.run();
+    const probe = jest.spyOn(BundlingProbe, 'run').mockReturnValue({ ...capabilities, dockerAvailable: true, dockerProblems: [] });
+    try {
+      const outputDir = path.join(dir, 'output');
+      fs.mkdirSync(outputDir);
+      expect((Bundling.bundle(options('second')) as any).options.bundling.local.tryBundle(outputDir, {})).toEqual(false);
+      expect(fs.existsSync(path.join(outputDir, 'bootstrap'))).toBe(false);
+    } finally {
+      probe.mockRestore();
+      fs.rmSync(dir, { recursive: true, force: true });
+    }
+  });
+});
+
+describe('bundlingCompilerCache', () => {
+  it('compiles the crates with sccache in Docker builds', () => {
+    const bundling = (Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+      compilerCache: {
+        directory: '/cache/sccache',
+        maxSize: cdk.Size.gibibytes(1),
+      },
+    }) as any).options.bundling;
+
+    expect(bundling.volumes).toContainEqual({ hostPath: '/cache/sccache', containerPath: '/cargo-lambda-cdk/sccache' });
+    expect(bundling.environment).toMatchObject({ SCCACHE_DIR: '/cargo-lambda-cdk/sccache', SCCACHE_CACHE_SIZE: '1024M' });
+    expect(bundling.command[2]).toMatch(/^if command -v sccache > \/dev\/null; then export RUSTC_WRAPPER=sccache; .* && cargo lambda build .* && \(if .*sccache --show-stats/);
+  });
+
+  it('doesn\'t use sccache by default', () => {
+    const bundling = (Bundling.bundle({
+      manifestPath: getTestManifestPath(),
+      forcedDockerBundling: true,
+    }) as any).options.bundling;
+
+    expect(bundling.command[2]).not.toContain('sccache');
+    expect(bundling.environment?.SCCACHE_DIR).toBeUndefined();
+  });
+});
@@ -1,5 +1,7 @@
+import { mkdirSync, mkdtempSync, utimesSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
 import { join } from 'node:path';
-import { getManifest, getManifestPath } from '../src/cargo';
+import { getBuildRoot, getCargoProject, getManifest, getManifestPath, resolveBinary } from '../src/cargo';
 
 describe('getManifestPath', () => {
   it('works with a path to an existent Cargo.toml file', () => {
@@ -47,4 +49,145 @@ describe('getManifest', () => {
     expect(manifest.bin && manifest.bin[0].name).toEqual('binary1');
     expect(manifest.workspace).toBeUndefined();
   });
-});
\ No newline at end of file
+});
+
+describe('getCargoProject', () => {
+  it('finds implicit binaries', () => {
+    const fixture = join(__dirname, 'fixtures/multi-binary/Cargo.toml');
+    const project = getCargoProject(fixture);
+    expect(project.isWorkspace).toBe(false);
+    expect(project.package?.name).toEqual('multi-binary');
+    expect(project.package?.binaries.sort()).toEqual(['handler', 'multi-binary']);
+  });
+
+  it('finds the workspace members', () => {
+    const fixture = join(__dirname, 'fixtures/cargo-workspace/Cargo.toml');
+    const project = getCargoProject(fixture);
+    expect(project.isWorkspace).toBe(true);
+    expect(project.package).toBeUndefined();
+    expect(project.packages.map(p => p.name).sort()).toEqual(['binary1', 'binary2']);
+  });
+
+  it('finds the other workspace members from a member', () => {
+    const fixture = join(__dirname, 'fixtures/cargo-workspace/binary1/Cargo.toml');
+    const project = getCargoProject(fixture);
+    expect(project.package?.name).toEqual('binary1');
+    expect(project.packages.map(p => p.name).sort()).toEqual(['binary1', 'binary2']);
+  });
+
+  it('resolves a project once, until its manifest changes', () => {
+    const dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-project-'));
+    const manifest = join(dir, 'Cargo.toml');
+    writeFileSync(manifest, '[package]\nname = "first"\n');
+    mkdirSync(join(dir, 'src'));
+    writeFileSync(join(dir, 'src', 'main.rs'), 'fn main() {}\n');
+
+    const project = getCargoProject(manifest);
+    expect(getCargoProject(manifest)).toBe(project);
+
+    writeFileSync(manifest, '[package]\nname = "second"\n');
+    utimesSync(manifest, new Date(), new Date(Date.now() + 10000));
+    expect(getCargoProject(manifest).package?.name).toEqual('second');
+  });
+});
+
+describe('resolveBinary', () => {
+  const project = (binaries: string[], defaultRun?: string) => {
+    const pkg = { name: 'package', manifestPath: 'Cargo.toml', binaries, defaultRun };
+    return { package: pkg, packages: [pkg], isWorkspace: false, workspaceRoot: '.', fromMetadata: true };
+  };
+
+  it('uses the only binary in the package', () => {
+    expect(resolveBinary(project(['package']))).toEqual({ name: 'package', select: false });
+  });
+
+  it('uses the default-run binary', () => {
+    expect(resolveBinary(project(['package', 'other'], 'other'))).toEqual({ name: 'other', select: true });
+  });
+
+  it('uses the binary name', () => {
+    expect(resolveBinary(project(['package', 'other']), 'other')).toEqual({ name: 'other', select: true });
+  });
+
+  it('fails with several binaries', () => {
+    expect(() => resolveBinary(project(['package', 'other']))).toThrow(
+      'there are more than one binaries declared in this Cargo package, use the option `binaryName` to specify the binary to build. Binaries found: package, other',
+    );
+  });
+
+  it('fails with a workspace', () => {
+    const workspace = {
+      packages: [
+        { name: 'binary1', manifestPath: 'binary1/Cargo.toml', binaries: ['binary1'] },
+        { name: 'binary2', manifestPath: 'binary2/Cargo.toml', binaries: ['binary2'] },
+      ],
+      isWorkspace: true,
+      workspaceRoot: '.',
+      fromMetadata: true,
+    };
+    expect(() => resolveBinary(workspace)).toThrow(
+      'the Cargo manifest is a workspace, use the option `binaryName` to specify the binary to build. Binaries found: binary1, binary2',
+    );
+  });
+
+  it('uses the package name to select the binary', () => {
+    const workspace = {
+      packages: [
+        { name: 'orders', manifestPath: 'orders/Cargo.toml', binaries: ['lambda'] },
+        { name: 'payments', manifestPath: 'payments/Cargo.toml', binaries: ['lambda', 'worker'], defaultRun: 'lambda' },
+      ],
+      isWorkspace: true,
+      workspaceRoot: '.',
+      fromMetadata: true,
+    };
+    expect(resolveBinary(workspace, undefined, 'orders')).toEqual({ name: 'lambda', select: false });
+    expect(resolveBinary(workspace, undefined, 'payments')).toEqual({ name: 'lambda', select: true });
+    expect(resolveBinary(workspace, 'worker', 'payments')).toEqual({ name: 'worker', select: true });
+    expect(() => resolveBinary(workspace, 'worker', 'orders')).toThrow(
+      'the binary `worker` was not found in the Cargo project. Binaries found: lambda',
+    );
+    expect(() => resolveBinary(workspace, 'lambda', 'order')).toThrow(
+      'the package `order` was not found in the Cargo workspace. Did you mean `orders`? Packages found: orders, payments',
+    );
+    // the packages are also known without `cargo metadata`
+    expect(() => resolveBinary({ ...workspace, fromMetadata: false }, 'lambda', 'order')).toThrow(
+      'the package `order` was not found in the Cargo workspace',
+    );
+  });
+
+  it('suggests similar binary names', () => {
+    expect(() => resolveBinary(project(['handler', 'package']), 'handlr')).toThrow(
+      'the binary `handlr` was not found in the Cargo project. Did you mean `handler`? Binaries found: handler, package',
+    );
+  });
+});
+
+describe('getBuildRoot', () => {
+  it('uses the package directory for single packages', () => {
+    const fixture = join(__dirname, 'fixtures/single-package');
+    expect(getBuildRoot(join(fixture, 'Cargo.toml'))).toEqual(fixture);
+  });
+
+  it('uses the workspace root for workspace members', () => {
+    const fixture = join(__dirname, 'fixtures/cargo-workspace');
+    expect(getBuildRoot(join(fixture, 'binary1/Cargo.toml'))).toEqual(fixture);
+  });
+
+  it('includes path dependencies', () => {
+    const fixture = join(__dirname, 'fixtures/path-dependency');
+    expect(getBuildRoot(join(fixture, 'function/Cargo.toml'))).toEqual(fixture);
+  });
+
+  it('fails with path dependencies far above the workspace', () => {
+    const dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-build-root-'));
+    const packageDir = join(dir, 'a/b/c/d/function');
+    mkdirSync(join(packageDir, 'src'), { recursive: true });
+    mkdirSync(join(dir, 'shared'));
+    writeFileSync(join(dir, 'shared/Cargo.toml'), '[package]\nname = "shared"\nversion = "0.1.0"\n');
+    writeFileSync(join(packageDir, 'Cargo.toml'), '[package]\nname = "function"\nversion = "0.1.0"\n\n[dependencies]\nshared = { path = "../../../../../shared" }\n');
+
+    expect(() => getBuildRoot(join(packageDir, 'Cargo.toml'))).toThrow(
+      `the path dependencies \`${join(dir, 'shared')}\` are too far from the workspace \`${packageDir}\``,
+    );
+  });
+});
@@ -0,0 +1,77 @@
+import { existsSync, readdirSync } from 'node:fs';
+import { join } from 'node:path';
+import { App, Stack } from 'aws-cdk-lib';
+import { Template } from 'aws-cdk-lib/assertions';
+import { Architecture, Function, Runtime } from 'aws-cdk-lib/aws-lambda';
+import { RustCode, RustExtension, RustFunction } from '../src/index';
+
+const fixtures = join(__dirname, 'fixtures/prebuilt');
+
+// the staged assets that contain a file
+function stagedAssets(outdir: string, file: string): string[] {
+  return readdirSync(outdir).filter(entry => entry.startsWith('asset.') && existsSync(join(outdir, entry, file)));
+}
+
+describe('RustCode.fromCargoLambdaOutput', () => {
+  it('deploys the output of a previous build', () => {
+    const app = new App();
+    const stack = new Stack(app);
+    new Function(stack, 'Function', {
+      runtime: Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: RustCode.fromCargoLambdaOutput(join(fixtures, 'x86-64'), { binaryName: 'simple-package' }),
+    });
+
+    const assembly = app.synth();
+    expect(stagedAssets(assembly.directory, 'bootstrap')).toHaveLength(1);
+  });
+
+  it('checks the architecture of the binary', () => {
+    const stack = new Stack(new App());
+    expect(() => new Function(stack, 'Function', {
+      runtime: Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: RustCode.fromCargoLambdaOutput(join(fixtures, 'bootstrap.zip')),
+    })).toThrow('was built for arm64, but the architecture is x86_64');
+  });
+
+  it('requires the name of extensions', () => {
+    expect(() => RustCode.fromCargoLambdaOutput(join(fixtures, 'arm64'), { lambdaExtension: true })).toThrow(
+      'the option `binaryName` is required for the output of an extension',
+    );
+  });
+});
+
+describe('prebuilt artifacts without the Cargo sources', () => {
+  // the tests run in a directory without Cargo.toml, so reading the sources would fail
+  it('deploys a function', () => {
+    const app = new App();
+    const stack = new Stack(app);
+    new RustFunction(stack, 'Function', {
+      architecture: Architecture.ARM_64,
+      bundling: {
+        prebuiltArtifact: join(fixtures, 'bootstrap.zip'),
+      },
+    });
+
+    const assembly = app.synth();
+    Template.fromStack(stack).hasResourceProperties('AWS::Lambda::Function', { Architectures: ['arm64'] });
+    // the zip file is staged as the asset
+    expect(readdirSync(assembly.directory).filter(entry => entry.startsWith('asset.') && entry.endsWith('.zip'))).toHaveLength(1);
+  });
+
+  it('deploys an extension', () => {
+    const app = new App();
+    const stack = new Stack(app);
+    new RustExtension(stack, 'Extension', {
+      architecture: Architecture.ARM_64,
+      binaryName: 'log-extension',
+      bundling: {
+        prebuiltArtifact: join(fixtures, 'arm64'),
+      },
+    });
+
+    const assembly = app.synth();
+    expect(stagedAssets(assembly.directory, 'extensions/log-extension')).toHaveLength(1);
+  });
+});
@@ -0,0 +1,88 @@
+import { mkdtempSync, rmSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { App, Duration, Stack } from 'aws-cdk-lib';
+import { Tracing } from 'aws-cdk-lib/aws-lambda';
+import { deployDefaults, getDeployConfig, resolveBuildConfig } from '../src/config';
+import { BuildSettingSource, Compiler } from '../src/types';
+
+const fixture = join(__dirname, 'fixtures/deploy-config/Cargo.toml');
+
+describe('getDeployConfig', () => {
+  it('merges CargoLambda.toml over the package metadata', () => {
+    const config = getDeployConfig(fixture);
+    expect(config.memory).toEqual(1024);
+    expect(config.timeout).toEqual(60);
+    expect(config.layers).toEqual(['arn:aws:lambda:us-east-1:123456789012:layer:telemetry:1']);
+  });
+
+  it('returns no settings without deploy configuration', () => {
+    expect(getDeployConfig(join(__dirname, 'fixtures/single-package/Cargo.toml'))).toEqual({});
+  });
+});
+
+describe('deployDefaults', () => {
+  it('uses the deploy settings as defaults', () => {
+    const stack = new Stack(new App());
+    const { options, conflicts } = deployDefaults(stack, 'function', fixture, {});
+
+    expect(options.memorySize).toEqual(1024);
+    expect(options.timeout?.toSeconds()).toEqual(60);
+    expect(options.tracing).toEqual(Tracing.ACTIVE);
+    expect(options.environment).toEqual({ API_URL: 'https://example.com', LOG_LEVEL: 'info' });
+    expect(options.layers).toHaveLength(1);
+    expect(conflicts).toEqual([]);
+  });
+
+  it('reports the props that override the deploy settings', () => {
+    const stack = new Stack(new App());
+    const { options, conflicts } = deployDefaults(stack, 'function', fixture, {
+      memorySize: 256,
+      timeout: Duration.seconds(60),
+      environment: { LOG_LEVEL: 'warn' },
+    });
+
+    expect(options.memorySize).toEqual(256);
+    expect(options.environment).toEqual({ API_URL: 'https://example.com', LOG_LEVEL: 'warn' });
+    expect(conflicts).toEqual([
+      '`environment.LOG_LEVEL` overrides `env.LOG_LEVEL` in the Cargo Lambda deploy settings',
+      '`memorySize` (256) overrides `memory` (1024) in the Cargo Lambda deploy settings',
+    ]);
+  });
+});
+
+describe('resolveBuildConfig', () => {
+  it('merges the build settings in precedence order', () => {
+    const { bundling, settings } = resolveBuildConfig(fixture, { profile: 'dev' });
+
+    expect(bundling.compiler).toEqual(Compiler.CARGO);
+    expect(bundling.features).toEqual(['telemetry']);
+    expect(bundling.include).toEqual([{ source: 'deploy.env' }]);
+    expect(bundling.profile).toEqual('dev');
+    expect(settings).toEqual([
+      { name: 'compiler', value: 'cargo', source: BuildSettingSource.CARGO_TOML },
+      { name: 'features', value: 'telemetry', source: BuildSettingSource.CARGO_LAMBDA_TOML },
+      { name: 'profile', value: 'dev', source: BuildSettingSource.BUNDLING_OPTIONS },
+      { name: 'include', value: 'deploy.env', source: BuildSettingSource.CARGO_LAMBDA_TOML },
+    ]);
+  });
+
+  it('uses the bundling options over the build settings', () => {
+    const { bundling } = resolveBuildConfig(fixture, { compiler: Compiler.CARGO_ZIGBUILD, features: [] });
+
+    expect(bundling.compiler).toEqual(Compiler.CARGO_ZIGBUILD);
+    expect(bundling.features).toEqual([]);
+  });
+
+  it('rejects the compilers that Cargo Lambda does not support', () => {
+    const dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-config-'));
+    try {
+      writeFileSync(join(dir, 'Cargo.toml'), '[package]\nname = "auto"\n\n[package.metadata.lambda.build]\ncompiler = "auto"\n');
+      expect(() => resolveBuildConfig(join(dir, 'Cargo.toml'), {})).toThrow(
+        'invalid compiler `auto` in the Cargo Lambda build settings, valid compilers are: cargo-zigbuild, cross, cargo',
+      );
+    } finally {
+      rmSync(dir, { recursive: true, force: true });
+    }
+  });
+});
@@ -0,0 +1,88 @@
+import { existsSync, readdirSync } from 'fs';
+import { join } from 'path';
+import { env } from 'process';
+import { App, Stack } from 'aws-cdk-lib';
+import { Annotations, Match, Template } from 'aws-cdk-lib/assertions';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { containerDockerfile } from '../src/container';
+import { ContainerBaseImage, RustContainerFunction, cargoLambdaVersion } from '../src/index';
+
+const forcedDockerBundling = !!env.FORCE_DOCKER_RUN || !cargoLambdaVersion();
+
+describe('containerDockerfile', () => {
+  it('uses the provided.al2023 base image', () => {
+    const dockerfile = containerDockerfile(ContainerBaseImage.PROVIDED_AL2023);
+    expect(dockerfile).toContain('FROM public.ecr.aws/lambda/provided:al2023');
+    expect(dockerfile).toContain('ENTRYPOINT [ "./bootstrap" ]');
+  });
+
+  it('uses the provided.al2 base image', () => {
+    const dockerfile = containerDockerfile(ContainerBaseImage.PROVIDED_AL2);
+    expect(dockerfile).toContain('FROM public.ecr.aws/lambda/provided:al2\n');
+  });
+
+  it('uses an empty base image', () => {
+    const dockerfile = containerDockerfile(ContainerBaseImage.SCRATCH);
+    expect(dockerfile).toContain('FROM scratch');
+    expect(dockerfile).toContain('ENTRYPOINT [ "/var/task/bootstrap" ]');
+  });
+});
+
+describe('CargoLambda.RustContainerFunction', () => {
+  describe('With single package Cargo project', () => {
+    const app = new App();
+    const stack = new Stack(app);
+    const testSource = join(__dirname, 'fixtures/single-package');
+
+    new RustContainerFunction(stack, 'rust container function', {
+      manifestPath: testSource,
+      architecture: Architecture.ARM_64,
+      bundling: {
+        forcedDockerBundling,
+      },
+    });
+
+    test('bundle function as a container image', () => {
+      const template = Template.fromStack(stack);
+      template.hasResourceProperties('AWS::Lambda::Function', {
+        PackageType: 'Image',
+        Architectures: ['arm64'],
+      });
+
+      app.synth();
+    });
+
+    test('build the image from a copy of the bundle', () => {
+      const assembly = app.synth();
+      const assets = readdirSync(assembly.directory);
+      const bundle = assets.find(asset => asset.startsWith('asset.') && existsSync(join(assembly.directory, asset, 'bootstrap')));
+      const context = assets.find(asset => asset.startsWith('asset.') && existsSync(join(assembly.directory, asset, 'Dockerfile')));
+
+      expect(bundle).toBeDefined();
+      expect(existsSync(join(assembly.directory, bundle!, 'Dockerfile'))).toBe(false);
+      expect(readdirSync(join(assembly.directory, context!)).sort()).toEqual(['Dockerfile', 'bundle']);
+      expect(existsSync(join(assembly.directory, context!, 'bundle', 'bootstrap'))).toBe(true);
+    });
+  });
+
+  describe('With the scratch base image', () => {
+    const stack = new Stack(new App({ context: { 'aws:cdk:bundling-stacks': [] } }));
+    new RustContainerFunction(stack, 'glibc', {
+      manifestPath: join(__dirname, 'fixtures/single-package'),
+      baseImage: ContainerBaseImage.SCRATCH,
+    });
+    new RustContainerFunction(stack, 'musl', {
+      manifestPath: join(__dirname, 'fixtures/single-package'),
+      baseImage: ContainerBaseImage.SCRATCH,
+      bundling: {
+        target: 'x86_64-unknown-linux-musl',
+      },
+    });
+
+    test('warns without a musl target', () => {
+      const annotations = Annotations.fromStack(stack);
+      annotations.hasWarning('/Default/glibc', Match.stringLikeRegexp('must be statically linked'));
+      annotations.hasNoWarning('/Default/musl', Match.anyValue());
+    });
+  });
+});
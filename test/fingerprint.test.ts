@@ -0,0 +1,121 @@
+import { cpSync, mkdirSync, mkdtempSync, rmSync, symlinkSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { getSourcePaths } from '../src/cargo';
+import { buildFingerprint, dockerImageIdentity, ignoredPaths } from '../src/fingerprint';
+
+describe('buildFingerprint', () => {
+  let dir: string;
+  let project: string;
+
+  const fingerprint = (root = project, targetDir = join(root, 'target')) => buildFingerprint(
+    root,
+    getSourcePaths(join(root, 'Cargo.toml')),
+    { command: 'cargo lambda build' },
+    ignoredPaths(root, targetDir),
+  );
+
+  beforeEach(() => {
+    dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-fingerprint-'));
+    project = join(dir, 'project');
+    cpSync(join(__dirname, 'fixtures/single-package'), project, { recursive: true });
+  });
+
+  afterEach(() => {
+    rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('changes with the sources', () => {
+    const before = fingerprint();
+    writeFileSync(join(project, 'src/main.rs'), 'fn main() { println!("changed"); }\n');
+    expect(fingerprint()).not.toEqual(before);
+  });
+
+  it('ignores the build outputs', () => {
+    const before = fingerprint();
+    mkdirSync(join(project, 'target/release'), { recursive: true });
+    writeFileSync(join(project, 'target/release/bootstrap'), 'binary');
+    expect(fingerprint()).toEqual(before);
+  });
+
+  it('includes the directories named like build outputs inside the sources', () => {
+    const before = fingerprint();
+    mkdirSync(join(project, 'src/target'), { recursive: true });
+    writeFileSync(join(project, 'src/target/mod.rs'), 'pub fn target() {}\n');
+    expect(fingerprint()).not.toEqual(before);
+  });
+
+  it('ignores the target directory of CARGO_TARGET_DIR', () => {
+    const targetDir = join(project, 'build');
+    const before = fingerprint(project, targetDir);
+    mkdirSync(join(targetDir, 'release'), { recursive: true });
+    writeFileSync(join(targetDir, 'release/bootstrap'), 'binary');
+    expect(fingerprint(project, targetDir)).toEqual(before);
+  });
+
+  it('follows the symbolic links', () => {
+    const shared = join(dir, 'shared');
+    mkdirSync(shared);
+    writeFileSync(join(shared, 'lib.rs'), 'pub fn shared() {}\n');
+    symlinkSync(shared, join(project, 'src/shared'));
+    symlinkSync('..', join(project, 'src/parent'));
+
+    const before = fingerprint();
+    writeFileSync(join(shared, 'lib.rs'), 'pub fn changed() {}\n');
+    expect(fingerprint()).not.toEqual(before);
+  });
+
+  it('doesn\'t depend on the location of the project', () => {
+    const copy = join(dir, 'copy');
+    cpSync(project, copy, { recursive: true });
+    expect(fingerprint(copy)).toEqual(fingerprint());
+  });
+
+  it('changes with the build settings', () => {
+    const paths = getSourcePaths(join(project, 'Cargo.toml'));
+    expect(buildFingerprint(project, paths, { command: 'cargo lambda build --release' })).not.toEqual(
+      buildFingerprint(project, paths, { command: 'cargo lambda build' }),
+    );
+  });
+});
+
+describe('getSourcePaths', () => {
+  it('includes the directory of a single package', () => {
+    const fixture = join(__dirname, 'fixtures/single-package');
+    expect(getSourcePaths(join(fixture, 'Cargo.toml'))).toContain(fixture);
+  });
+
+  it('includes the path dependencies', () => {
+    const fixture = join(__dirname, 'fixtures/path-dependency');
+    const paths = getSourcePaths(join(fixture, 'function/Cargo.toml'));
+    expect(paths).toContain(join(fixture, 'function'));
+    expect(paths).toContain(join(fixture, 'shared'));
+  });
+
+  it('includes the members of a virtual workspace', () => {
+    const fixture = join(__dirname, 'fixtures/cargo-workspace');
+    const paths = getSourcePaths(join(fixture, 'Cargo.toml'));
+    expect(paths).toContain(join(fixture, 'binary1'));
+    expect(paths).toContain(join(fixture, 'binary2'));
+    expect(paths).toContain(join(fixture, 'Cargo.toml'));
+    expect(paths).not.toContain(fixture);
+  });
+
+  it('only includes the member that is built', () => {
+    const fixture = join(__dirname, 'fixtures/cargo-workspace');
+    const paths = getSourcePaths(join(fixture, 'binary1/Cargo.toml'));
+    expect(paths).toContain(join(fixture, 'binary1'));
+    expect(paths).not.toContain(join(fixture, 'binary2'));
+  });
+});
+
+describe('dockerImageIdentity', () => {
+  it('is the image reference, with the digest that it pins', () => {
+    const digest = `sha256:${'0'.repeat(64)}`;
+    expect(dockerImageIdentity('ghcr.io/cargo-lambda/cargo-lambda')).toEqual({ image: 'ghcr.io/cargo-lambda/cargo-lambda' });
+    expect(dockerImageIdentity(`ghcr.io/cargo-lambda/cargo-lambda@${digest}`)).toEqual({
+      image: `ghcr.io/cargo-lambda/cargo-lambda@${digest}`,
+      digest,
+    });
+  });
+});
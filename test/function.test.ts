@@ -1,8 +1,9 @@
 import { join } from 'path';
 import { env } from 'process';
 import { App, Stack } from 'aws-cdk-lib';
+import { Annotations, Match, Template } from 'aws-cdk-lib/assertions';
 import { LogGroup, RetentionDays } from 'aws-cdk-lib/aws-logs';
-import { RustFunction, cargoLambdaVersion } from '../src/index';
+import { RustFunction, RustWorkspace, cargoLambdaVersion } from '../src/index';
 
 const forcedDockerBundling = !!env.FORCE_DOCKER_RUN || !cargoLambdaVersion();
 
@@ -88,4 +89,30 @@ describe('CargoLambda.RustFunction', () => {
       app.synth();
     });
   });
+
+  describe('With Cargo Lambda deploy settings', () => {
+    const testSource = join(__dirname, 'fixtures/deploy-config');
+
+    it('ignores the settings by default', () => {
+      const stack = new Stack(new App());
+      new RustFunction(stack, 'rust function', { manifestPath: testSource });
+      Template.fromStack(stack).hasResourceProperties('AWS::Lambda::Function', { MemorySize: Match.absent() });
+    });
+
+    it('applies the settings when enabled', () => {
+      const stack = new Stack(new App());
+      new RustFunction(stack, 'rust function', { manifestPath: testSource, applyDeployConfig: true });
+      Template.fromStack(stack).hasResourceProperties('AWS::Lambda::Function', { MemorySize: 1024, Timeout: 60 });
+    });
+
+    it('warns that workspaces ignore the settings', () => {
+      const stack = new Stack(new App());
+      const workspace = new RustWorkspace(stack, 'workspace', {
+        manifestPath: join(__dirname, 'fixtures/cargo-workspace'),
+        binaries: ['binary1'],
+      });
+      new RustFunction(stack, 'rust function', { workspace, binaryName: 'binary1', applyDeployConfig: true });
+      Annotations.fromStack(stack).hasWarning('*', Match.stringLikeRegexp('deploy settings are not applied'));
+    });
+  });
 });
@@ -0,0 +1,65 @@
+import { join } from 'node:path';
+import { resolveIncludes } from '../src/include';
+
+const packageDir = join(__dirname, 'fixtures/deploy-config');
+
+describe('resolveIncludes', () => {
+  it('keeps the path relative to the package without a destination', () => {
+    expect(resolveIncludes(packageDir, [{ source: 'deploy.env' }])).toEqual([
+      { source: join(packageDir, 'deploy.env'), destination: 'deploy.env', directory: false },
+    ]);
+  });
+
+  it('copies a file or directory to its destination', () => {
+    expect(resolveIncludes(packageDir, [{ source: 'src', destination: 'config/src' }])).toEqual([
+      { source: join(packageDir, 'src'), destination: 'config/src', directory: true },
+    ]);
+  });
+
+  it('copies the matches of a glob inside the destination', () => {
+    expect(resolveIncludes(packageDir, [{ source: '**/*.rs', destination: 'sources' }])).toEqual([
+      { source: join(packageDir, 'src/main.rs'), destination: 'sources/src/main.rs', directory: false },
+    ]);
+    expect(resolveIncludes(packageDir, [{ source: '*.toml' }]).map(include => include.destination)).toEqual([
+      'Cargo.toml',
+      'CargoLambda.toml',
+    ]);
+  });
+
+  it('only matches dotfiles with patterns that start with a dot', () => {
+    expect(resolveIncludes(packageDir, [{ source: '*.env' }]).map(include => include.destination)).toEqual(['deploy.env']);
+    expect(resolveIncludes(packageDir, [{ source: '.*.env' }]).map(include => include.destination)).toEqual(['.hidden.env']);
+    expect(resolveIncludes(packageDir, [{ source: '**/.hidden.env' }]).map(include => include.destination)).toEqual(['.hidden.env']);
+  });
+
+  it('fails with sources that do not exist', () => {
+    expect(() => resolveIncludes(packageDir, [{ source: 'missing.json' }])).toThrow(
+      'the include source `missing.json` doesn\'t exist',
+    );
+    expect(() => resolveIncludes(packageDir, [{ source: 'templates/*.html' }])).toThrow(
+      'the include source `templates/*.html` doesn\'t match any file',
+    );
+  });
+
+  it('fails with destinations outside the bundling output', () => {
+    expect(() => resolveIncludes(packageDir, [{ source: 'deploy.env', destination: '../deploy.env' }])).toThrow(
+      'the include destination `../deploy.env` must be inside the bundling output',
+    );
+    expect(() => resolveIncludes(packageDir, [{ source: 'deploy.env', destination: 'config/../../deploy.env' }])).toThrow(
+      'the include destination `config/../../deploy.env` must be inside the bundling output',
+    );
+  });
+
+  it('accepts destinations that start with two dots', () => {
+    expect(resolveIncludes(packageDir, [{ source: 'deploy.env', destination: '..config/deploy.env' }]).map(include => include.destination)).toEqual([
+      '..config/deploy.env',
+    ]);
+  });
+
+  it('fails with sources outside the build root', () => {
+    expect(() => resolveIncludes(packageDir, [{ source: '../single-package/Cargo.toml' }], packageDir)).toThrow(
+      `the include source \`${join(packageDir, '../single-package/Cargo.toml')}\` must be inside the directory \`${packageDir}\` of the build`,
+    );
+    expect(resolveIncludes(packageDir, [{ source: '../single-package/Cargo.toml', destination: 'Other.toml' }], join(packageDir, '..'))).toHaveLength(1);
+  });
+});
@@ -0,0 +1,208 @@
+import { spawnSync } from 'node:child_process';
+import { existsSync, mkdirSync, mkdtempSync, readdirSync, rmSync, utimesSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { env } from 'node:process';
+import { App, Stack, Stage } from 'aws-cdk-lib';
+import { RustFunction, RustWorkspace, cargoLambdaVersion } from '../src/index';
+import { PARALLEL_BUILDS_CONTEXT, PrebuildQueue } from '../src/prebuild';
+import { BuildJob, BuildResult, runBuilds } from '../src/runner';
+
+const forcedDockerBundling = !!env.FORCE_DOCKER_RUN || !cargoLambdaVersion();
+
+describe('PrebuildQueue', () => {
+  it('is only enabled by the context', () => {
+    expect(PrebuildQueue.of(new Stack(new App()))).toBeUndefined();
+    expect(PrebuildQueue.of(new Stack(new App({ context: { [PARALLEL_BUILDS_CONTEXT]: 'false' } })))).toBeUndefined();
+    expect(PrebuildQueue.of(new Stack(new App({ context: { [PARALLEL_BUILDS_CONTEXT]: true } })))).toBeDefined();
+    expect(PrebuildQueue.of(new Stack(new App({ context: { [PARALLEL_BUILDS_CONTEXT]: '4' } })))).toBeDefined();
+  });
+
+  it('rejects an invalid number of parallel builds', () => {
+    const stack = new Stack(new App({ context: { [PARALLEL_BUILDS_CONTEXT]: 'many' } }));
+    expect(() => PrebuildQueue.of(stack)).toThrow('must be `true` or the maximum number of parallel builds, got `many`');
+  });
+
+  it('has one queue per app, shared with the nested stages', () => {
+    const app = new App({ context: { [PARALLEL_BUILDS_CONTEXT]: 2 } });
+    const first = new Stack(app, 'First');
+    const second = new Stack(app, 'Second');
+    const stage = new Stage(app, 'Stage');
+
+    expect(PrebuildQueue.of(first)).toBe(PrebuildQueue.of(second));
+    expect(PrebuildQueue.of(new Stack(stage, 'Third'))).toBe(PrebuildQueue.of(first));
+    expect(PrebuildQueue.of(new Stack(new App({ context: { [PARALLEL_BUILDS_CONTEXT]: 2 } })))).not.toBe(PrebuildQueue.of(first));
+  });
+
+  it('marks the deferred bundles as pending', () => {
+    const outputDir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-prebuild-'));
+    const queue = PrebuildQueue.of(new Stack(new App({ context: { [PARALLEL_BUILDS_CONTEXT]: true } })))!;
+
+    expect(PrebuildQueue.isPending(outputDir)).toBe(false);
+    queue.defer(outputDir);
+    expect(PrebuildQueue.isPending(outputDir)).toBe(true);
+    rmSync(outputDir, { recursive: true, force: true });
+  });
+});
+
+describe('prebuild phase', () => {
+  let outdir: string;
+  let jobs: BuildJob[];
+
+  // runs the builds one after the other in this process, the runner process needs the compiled sources
+  const runInProcess = (status?: number) => (pending: BuildJob[]): BuildResult[] => {
+    jobs.push(...pending);
+    return pending.map(job => ({
+      id: job.id,
+      status: status ?? spawnSync(job.command, job.args, { cwd: job.cwd, env: job.env, stdio: 'inherit' }).status,
+    }));
+  };
+
+  const stagedAssets = () => readdirSync(outdir).filter(entry => entry.startsWith('asset.'));
+  // a different fingerprint than the builds of the other tests, which would be reused
+  const bundling = () => ({ forcedDockerBundling, environment: { PREBUILD_TEST: outdir } });
+
+  beforeEach(() => {
+    outdir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-prebuild-'));
+    jobs = [];
+  });
+
+  afterEach(() => {
+    PrebuildQueue.useRunner();
+    rmSync(outdir, { recursive: true, force: true });
+  });
+
+  it('builds the functions and the workspaces when the app is synthesized', () => {
+    PrebuildQueue.useRunner(runInProcess());
+    const app = new App({ outdir, context: { [PARALLEL_BUILDS_CONTEXT]: 2 } });
+    const stack = new Stack(app, 'Stack');
+    new RustFunction(stack, 'Function', {
+      manifestPath: join(__dirname, 'fixtures/single-package'),
+      bundling: bundling(),
+    });
+    const workspace = new RustWorkspace(stack, 'Workspace', {
+      manifestPath: join(__dirname, 'fixtures/cargo-workspace'),
+      binaries: ['binary1', 'binary2'],
+      bundling: bundling(),
+    });
+    new RustFunction(stack, 'Binary1', { workspace, binaryName: 'binary1' });
+    new RustFunction(stack, 'Binary2', { workspace, binaryName: 'binary2' });
+
+    // nothing is built until the synthesis
+    expect(jobs).toHaveLength(0);
+    expect(stagedAssets().every(asset => PrebuildQueue.isPending(join(outdir, asset)))).toBe(true);
+
+    // the builds don't depend on the validation
+    app.synth({ skipValidation: true });
+    expect(jobs.map(job => job.id).sort()).toEqual(['Stack/Function', 'Stack/Workspace']);
+    for (const asset of stagedAssets()) {
+      expect(PrebuildQueue.isPending(join(outdir, asset))).toBe(false);
+    }
+    expect(stagedAssets().filter(asset => existsSync(join(outdir, asset, 'bootstrap')))).toHaveLength(3);
+  });
+
+  it('fails the synthesis when a build fails', () => {
+    PrebuildQueue.useRunner(runInProcess(101));
+    const app = new App({ outdir, context: { [PARALLEL_BUILDS_CONTEXT]: true } });
+    new RustFunction(new Stack(app, 'Stack'), 'Function', {
+      manifestPath: join(__dirname, 'fixtures/single-package'),
+      bundling: bundling(),
+    });
+
+    expect(() => app.synth({ skipValidation: true })).toThrow('the Rust build of `Stack/Function` failed: exit status 101');
+  });
+});
+
+describe('runBuilds', () => {
+  let dir: string;
+
+  const job = (id: string, lockDir: string, script: string): BuildJob => ({
+    id,
+    command: process.execPath,
+    args: ['-e', script],
+    cwd: dir,
+    env: process.env,
+    lockDir: join(dir, lockDir),
+  });
+
+  beforeEach(() => {
+    dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-runner-'));
+  });
+
+  afterEach(() => {
+    rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('reports the status of each build', async () => {
+    const results = await runBuilds([
+      job('ok', 'first', 'process.exit(0)'),
+      job('failed', 'second', 'process.exit(3)'),
+    ], 2);
+
+    expect(results.sort((a, b) => a.id.localeCompare(b.id))).toEqual([
+      { id: 'failed', status: 3 },
+      { id: 'ok', status: 0 },
+    ]);
+  });
+
+  it('runs one build at a time in the same target directory', async () => {
+    // each build fails if it finds the file of the other build
+    const script = (name: string) => `
+      const fs = require('fs');
+      if (fs.existsSync('running')) process.exit(1);
+      fs.writeFileSync('running', '${name}');
+      setTimeout(() => fs.unlinkSync('running'), 200);
+    `;
+
+    const results = await runBuilds([job('first', 'target', script('first')), job('second', 'target', script('second'))], 2);
+    expect(results.map(result => result.status)).toEqual([0, 0]);
+    expect(existsSync(join(dir, 'target', '.cargo-lambda-cdk.lock'))).toBe(false);
+  });
+
+  it('takes over the lock of a process that does not exist', async () => {
+    mkdirSync(join(dir, 'target'));
+    writeFileSync(join(dir, 'target', '.cargo-lambda-cdk.lock'), '999999999');
+
+    const results = await runBuilds([job('build', 'target', 'process.exit(0)')], 1);
+    expect(results).toEqual([{ id: 'build', status: 0 }]);
+  });
+
+  it('takes over an empty lock file that is not recent', async () => {
+    mkdirSync(join(dir, 'target'));
+    const lockFile = join(dir, 'target', '.cargo-lambda-cdk.lock');
+    writeFileSync(lockFile, '');
+    const past = new Date(Date.now() - 60 * 1000);
+    utimesSync(lockFile, past, past);
+
+    const results = await runBuilds([job('build', 'target', 'process.exit(0)')], 1);
+    expect(results).toEqual([{ id: 'build', status: 0 }]);
+  });
+
+  it('gives up on the lock of another process after the timeout', async () => {
+    mkdirSync(join(dir, 'target'));
+    const lockFile = join(dir, 'target', '.cargo-lambda-cdk.lock');
+    writeFileSync(lockFile, `${process.pid}`);
+
+    const results = await runBuilds([job('build', 'target', 'process.exit(0)'), job('other', 'other', 'process.exit(0)')], 1, 100);
+    expect(results.sort((a, b) => a.id.localeCompare(b.id))).toEqual([
+      {
+        id: 'build',
+        status: null,
+        error: `the target directory is locked by the process ${process.pid}, gave up after waiting 1s, remove the lock file \`${lockFile}\` if that process is not building`,
+      },
+      { id: 'other', status: 0 },
+    ]);
+  });
+
+  it('prefixes the output with the construct path', async () => {
+    const write = jest.spyOn(process.stderr, 'write').mockImplementation(() => true);
+    try {
+      await runBuilds([job('Stack/Function', 'target', 'console.log("Compiling"); console.error("Finished")')], 1);
+      const output = write.mock.calls.map(call => call[0]);
+      expect(output).toContain('[Stack/Function] Compiling\n');
+      expect(output).toContain('[Stack/Function] Finished\n');
+    } finally {
+      write.mockRestore();
+    }
+  });
+});
@@ -0,0 +1,129 @@
+import { chmodSync, mkdtempSync, rmSync, writeFileSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { BundlingProbe, imageHasCommand, localBuildProblems, localCompiler, rustTarget, validateCompilerArchitecture } from '../src/probe';
+import { Compiler } from '../src/types';
+
+describe('rustTarget', () => {
+  it('uses the glibc target for the architecture', () => {
+    expect(rustTarget()).toEqual('x86_64-unknown-linux-gnu');
+    expect(rustTarget(Architecture.ARM_64)).toEqual('aarch64-unknown-linux-gnu');
+  });
+
+  it('removes the glibc version from explicit targets', () => {
+    expect(rustTarget(Architecture.ARM_64, 'aarch64-unknown-linux-gnu.2.26')).toEqual('aarch64-unknown-linux-gnu');
+    expect(rustTarget(Architecture.X86_64, 'x86_64-unknown-linux-musl')).toEqual('x86_64-unknown-linux-musl');
+  });
+});
+
+describe('localCompiler', () => {
+  it('keeps explicit compilers', () => {
+    expect(localCompiler(Compiler.CROSS, Architecture.ARM_64)).toEqual(Compiler.CROSS);
+    expect(localCompiler(undefined, Architecture.ARM_64)).toBeUndefined();
+  });
+
+  it('cross compiles automatically for other architectures', () => {
+    const other = process.arch === 'arm64' ? Architecture.X86_64 : Architecture.ARM_64;
+    expect(localCompiler(Compiler.AUTO, other)).toBeUndefined();
+  });
+
+  it('cross compiles automatically for the GLIBC of provided.al2', () => {
+    const host = process.arch === 'arm64' ? Architecture.ARM_64 : Architecture.X86_64;
+    expect(localCompiler(Compiler.AUTO, host, 'provided.al2')).toBeUndefined();
+  });
+});
+
+describe('validateCompilerArchitecture', () => {
+  const host = process.arch === 'arm64' ? Architecture.ARM_64 : Architecture.X86_64;
+  const other = process.arch === 'arm64' ? Architecture.X86_64 : Architecture.ARM_64;
+
+  it('rejects plain Cargo for another architecture', () => {
+    expect(() => validateCompilerArchitecture(Compiler.CARGO, other)).toThrow('the compiler `cargo` doesn\'t cross compile');
+  });
+
+  it('accepts plain Cargo for the host architecture, and the compilers that cross compile', () => {
+    validateCompilerArchitecture(Compiler.CARGO, host);
+    validateCompilerArchitecture(Compiler.CARGO_ZIGBUILD, other);
+    validateCompilerArchitecture(Compiler.AUTO, other);
+  });
+});
+
+describe('imageHasCommand', () => {
+  let dir: string;
+  let docker: string | undefined;
+
+  beforeEach(() => {
+    // a fake Docker that runs the command of the container on the host
+    dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-docker-'));
+    writeFileSync(join(dir, 'docker'), [
+      '#!/bin/sh',
+      'if [ "$5" = "broken-image" ]; then exit 125; fi',
+      'shift 5',
+      'exec sh "$@"',
+      '',
+    ].join('\n'));
+    chmodSync(join(dir, 'docker'), 0o755);
+    docker = process.env.CDK_DOCKER;
+    process.env.CDK_DOCKER = join(dir, 'docker');
+  });
+
+  afterEach(() => {
+    if (docker === undefined) {
+      delete process.env.CDK_DOCKER;
+    } else {
+      process.env.CDK_DOCKER = docker;
+    }
+    BundlingProbe.clearCache();
+    rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('finds the commands of the image', () => {
+    expect(imageHasCommand('custom-image', 'sh')).toBe(true);
+    expect(imageHasCommand('custom-image', 'cargo-lambda-cdk-missing-command')).toBe(false);
+  });
+
+  it('does not know the commands of an image that cannot run', () => {
+    expect(imageHasCommand('broken-image', 'sh')).toBeUndefined();
+  });
+});
+
+describe('BundlingProbe', () => {
+  afterEach(() => BundlingProbe.clearCache());
+
+  it('reports why each bundling method cannot run', () => {
+    const capabilities = BundlingProbe.run({ architecture: Architecture.ARM_64, compiler: Compiler.CARGO_ZIGBUILD });
+
+    expect(capabilities.localCompiler).toEqual(Compiler.CARGO_ZIGBUILD);
+    expect(capabilities.rustTarget).toEqual('aarch64-unknown-linux-gnu');
+    expect(capabilities.canBuildLocally).toEqual(capabilities.localProblems.length === 0);
+    expect(capabilities.dockerAvailable).toEqual(capabilities.dockerProblems.length === 0);
+  });
+
+  it('caches the results for the same target', () => {
+    expect(BundlingProbe.run()).toBe(BundlingProbe.run());
+  });
+});
+
+describe('localBuildProblems', () => {
+  const capabilities = {
+    cargoLambdaVersion: '1.5.0',
+    localCompiler: Compiler.CARGO_ZIGBUILD,
+    localCompilerInstalled: false,
+    rustTarget: 'aarch64-unknown-linux-gnu',
+    rustTargetInstalled: false,
+    dockerAvailable: false,
+    canBuildLocally: false,
+    localProblems: ['the Rust target `aarch64-unknown-linux-gnu` is not installed'],
+    dockerProblems: [],
+  };
+
+  it('only requires Cargo Lambda by default', () => {
+    expect(localBuildProblems(capabilities)).toEqual([]);
+    expect(localBuildProblems({ ...capabilities, cargoLambdaVersion: undefined })).toHaveLength(1);
+  });
+
+  it('reports the missing tools with the strict probe', () => {
+    expect(localBuildProblems(capabilities, true)).toEqual(capabilities.localProblems);
+  });
+});
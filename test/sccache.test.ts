@@ -0,0 +1,101 @@
+import { execFileSync } from 'node:child_process';
+import { chmodSync, mkdtempSync, readFileSync, rmSync, writeFileSync } from 'node:fs';
+import { createServer } from 'node:net';
+import { tmpdir } from 'node:os';
+import { delimiter, join } from 'node:path';
+import { Size } from 'aws-cdk-lib';
+import { dockerSccache, freeSccacheServerPort, localSccacheEnvironment, prebuildSccacheCommand, sccacheServerPort, sccacheStats } from '../src/sccache';
+
+describe('sccache', () => {
+  let dir: string;
+  let path: string | undefined;
+
+  beforeAll(() => {
+    // a fake sccache that reports the statistics of a build
+    dir = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-sccache-'));
+    writeFileSync(join(dir, 'sccache'), [
+      '#!/bin/sh',
+      'if [ -n "$SCCACHE_TEST_LOG" ]; then echo "$1" >> "$SCCACHE_TEST_LOG"; fi',
+      'if [ "$1" = "--start-server" ] && [ -n "$SCCACHE_TEST_RUNNING" ]; then exit 2; fi',
+      'if [ "$1" = "--show-stats" ]; then',
+      '  echo \'{"stats":{"cache_hits":{"counts":{"Rust":7,"C/C++":1}},"cache_misses":{"counts":{"Rust":3}}}}\'',
+      'fi',
+      '',
+    ].join('\n'));
+    chmodSync(join(dir, 'sccache'), 0o755);
+
+    path = process.env.PATH;
+    process.env.PATH = `${dir}${delimiter}${path}`;
+  });
+
+  afterAll(() => {
+    process.env.PATH = path;
+    rmSync(dir, { recursive: true, force: true });
+  });
+
+  it('sets the wrapper and the cache directory of local builds', () => {
+    expect(localSccacheEnvironment({ directory: '/cache/sccache', maxSize: Size.gibibytes(2) })).toEqual({
+      RUSTC_WRAPPER: 'sccache',
+      SCCACHE_SERVER_PORT: `${sccacheServerPort('/cache/sccache', '2048M')}`,
+      SCCACHE_DIR: '/cache/sccache',
+      SCCACHE_CACHE_SIZE: '2048M',
+    });
+  });
+
+  it('uses a dedicated server for each cache directory and size', () => {
+    const port = sccacheServerPort('/cache/sccache', '2048M');
+    expect(port).toBeGreaterThan(4226);
+    expect(port).toBeLessThan(65536);
+    expect(sccacheServerPort('/cache/sccache', '2048M')).toBe(port);
+    expect(sccacheServerPort('/cache/sccache', '1024M')).not.toBe(port);
+  });
+
+  it('keeps the wrapper of the user', () => {
+    const write = jest.spyOn(process.stderr, 'write').mockImplementation(() => true);
+    try {
+      expect(localSccacheEnvironment({ directory: '/cache/sccache' }, { RUSTC_WRAPPER: 'cachepot' })).toBeUndefined();
+      expect(write.mock.calls.map(call => call[0])).toContain('RUSTC_WRAPPER is already set to `cachepot`, the Rust build runs with it and without the compiler cache.\n');
+    } finally {
+      write.mockRestore();
+    }
+  });
+
+  it('reports the statistics of the prebuild phase builds and stops their server', () => {
+    const log = join(dir, 'started.log');
+    const env = { ...process.env, SCCACHE_TEST_LOG: log };
+    const output = execFileSync('sh', ['-c', prebuildSccacheCommand('echo build')], { env, stdio: ['ignore', 'pipe', 'ignore'] });
+    expect(output.toString()).toEqual('build\n');
+    expect(readFileSync(log).toString()).toEqual('--start-server\n--show-stats\n--stop-server\n');
+  });
+
+  it('doesn\'t stop a server that the build didn\'t start', () => {
+    const log = join(dir, 'running.log');
+    const env = { ...process.env, SCCACHE_TEST_LOG: log, SCCACHE_TEST_RUNNING: '1' };
+    expect(() => execFileSync('sh', ['-c', prebuildSccacheCommand('exit 3')], { env, stdio: 'ignore' })).toThrow();
+    expect(readFileSync(log).toString()).toEqual('--start-server\n');
+  });
+
+  it('gives each prebuild phase build a free port', async () => {
+    const taken = sccacheServerPort('/cache/sccache', 'taken');
+    const server = createServer();
+    await new Promise<void>(done => server.listen(taken, '127.0.0.1', done));
+    try {
+      const port = freeSccacheServerPort('/cache/sccache', 'taken');
+      expect(port).not.toBe(taken);
+      expect(freeSccacheServerPort('/cache/sccache', 'taken')).not.toBe(port);
+    } finally {
+      server.close();
+    }
+  });
+
+  it('reads the hits and misses', () => {
+    expect(sccacheStats(process.env)).toEqual({ hits: 8, misses: 3 });
+  });
+
+  it('mounts the cache directory in Docker builds', () => {
+    const sccache = dockerSccache({ directory: '/cache/sccache' });
+    expect(sccache.volume).toEqual({ hostPath: '/cache/sccache', containerPath: '/cargo-lambda-cdk/sccache' });
+    expect(sccache.environment).toEqual({ SCCACHE_DIR: '/cargo-lambda-cdk/sccache' });
+    expect(sccache.setup).toContain('export RUSTC_WRAPPER=sccache');
+  });
+});
@@ -0,0 +1,47 @@
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { runtimeTarget, validateRuntimeCompiler, validateTarget } from '../src/target';
+import { Compiler } from '../src/types';
+
+describe('runtimeTarget', () => {
+  it('uses the GLIBC version of provided.al2 with cargo-zigbuild', () => {
+    expect(runtimeTarget('provided.al2', Architecture.ARM_64)).toEqual('aarch64-unknown-linux-gnu.2.26');
+    expect(runtimeTarget('provided.al2', Architecture.X86_64, Compiler.CARGO_ZIGBUILD)).toEqual('x86_64-unknown-linux-gnu.2.26');
+  });
+
+  it('uses the default target otherwise', () => {
+    expect(runtimeTarget('provided.al2023', Architecture.ARM_64)).toBeUndefined();
+    expect(runtimeTarget('provided.al2', Architecture.ARM_64, Compiler.CARGO)).toBeUndefined();
+  });
+});
+
+describe('validateRuntimeCompiler', () => {
+  it('accepts the compilers that build for the GLIBC of the runtime', () => {
+    validateRuntimeCompiler('provided.al2', Compiler.CARGO_ZIGBUILD);
+    validateRuntimeCompiler('provided.al2', Compiler.CARGO, 'x86_64-unknown-linux-musl');
+    validateRuntimeCompiler('provided.al2023', Compiler.CARGO);
+  });
+
+  it('fails with compilers that use the GLIBC of the build environment', () => {
+    expect(() => validateRuntimeCompiler('provided.al2', Compiler.CROSS)).toThrow(
+      'the compiler `cross` builds the binary for the GLIBC of the build environment, but the runtime provided.al2 provides GLIBC 2.26',
+    );
+  });
+});
+
+describe('validateTarget', () => {
+  it('accepts GNU and musl targets for the architecture', () => {
+    validateTarget('x86_64-unknown-linux-gnu', Architecture.X86_64);
+    validateTarget('aarch64-unknown-linux-musl', Architecture.ARM_64, 'provided.al2');
+    validateTarget('aarch64-unknown-linux-gnu.2.26', Architecture.ARM_64, 'provided.al2');
+  });
+
+  it('fails with targets that do not run in the function', () => {
+    expect(() => validateTarget('x86_64-apple-darwin')).toThrow('the target `x86_64-apple-darwin` is not supported');
+    expect(() => validateTarget('x86_64-unknown-linux-musl', Architecture.ARM_64)).toThrow(
+      'the target `x86_64-unknown-linux-musl` doesn\'t match the architecture arm64, use a target that starts with `aarch64-`',
+    );
+    expect(() => validateTarget('x86_64-unknown-linux-gnu.2.34', Architecture.X86_64, 'provided.al2')).toThrow(
+      'the target `x86_64-unknown-linux-gnu.2.34` requires GLIBC 2.34, but the runtime provided.al2 provides GLIBC 2.26',
+    );
+  });
+});
@@ -0,0 +1,51 @@
+import { join } from 'node:path';
+import { App, Stack } from 'aws-cdk-lib';
+import { Annotations, Match } from 'aws-cdk-lib/assertions';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { VerificationSeverity } from '../src/types';
+import { reportFindings, verifyBundle } from '../src/verify';
+
+const fixtures = join(__dirname, 'fixtures/prebuilt');
+
+describe('verifyBundle', () => {
+  it('accepts binaries built for the architecture and runtime', () => {
+    expect(verifyBundle(join(fixtures, 'x86-64/simple-package'), { runtime: 'provided.al2' })).toEqual([]);
+    expect(verifyBundle(join(fixtures, 'glibc-2.34'), { runtime: 'provided.al2023' })).toEqual([]);
+    expect(verifyBundle(join(fixtures, 'arm64'), { architecture: Architecture.ARM_64, lambdaExtension: true })).toEqual([]);
+  });
+
+  it('reports binaries built for a different architecture', () => {
+    expect(verifyBundle(join(fixtures, 'bootstrap.zip'), { architecture: Architecture.X86_64 })).toEqual([
+      '`bootstrap` was built for arm64, but the architecture is x86_64',
+    ]);
+  });
+
+  it('reports GLIBC versions that the runtime does not provide', () => {
+    expect(verifyBundle(join(fixtures, 'glibc-2.34'), { runtime: 'provided.al2' })).toEqual([
+      '`bootstrap` requires GLIBC 2.34, but the runtime provided.al2 provides GLIBC 2.26',
+    ]);
+  });
+
+  it('reports bundles without binaries', () => {
+    expect(verifyBundle(fixtures, { binaryNames: ['arm64'] })).toEqual([
+      'arm64: the bundle doesn\'t contain the `bootstrap` binary',
+    ]);
+  });
+});
+
+describe('reportFindings', () => {
+  const findings = ['`bootstrap` was built for arm64, but the architecture is x86_64'];
+
+  it('fails the synthesis', () => {
+    const stack = new Stack(new App());
+    expect(() => reportFindings(stack, findings, VerificationSeverity.ERROR)).toThrow(
+      'the bundle verification failed: `bootstrap` was built for arm64, but the architecture is x86_64',
+    );
+  });
+
+  it('reports warnings by default', () => {
+    const stack = new Stack(new App());
+    reportFindings(stack, findings);
+    Annotations.fromStack(stack).hasWarning('*', Match.stringLikeRegexp('was built for arm64'));
+  });
+});
@@ -0,0 +1,29 @@
+import { existsSync, mkdirSync, mkdtempSync, rmSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import { join } from 'node:path';
+import { DockerCache } from '../src/index';
+
+describe('DockerCache.prune', () => {
+  let hostDirectory: string;
+
+  beforeEach(() => {
+    hostDirectory = mkdtempSync(join(tmpdir(), 'cargo-lambda-cdk-cache-'));
+    mkdirSync(join(hostDirectory, 'cargo-home', 'registry'), { recursive: true });
+    mkdirSync(join(hostDirectory, 'target', 'project'), { recursive: true });
+  });
+
+  afterEach(() => {
+    rmSync(hostDirectory, { recursive: true, force: true });
+  });
+
+  it('removes the host directories', () => {
+    expect(DockerCache.prune({ hostDirectory })).toEqual([join(hostDirectory, 'target'), join(hostDirectory, 'cargo-home')]);
+    expect(existsSync(join(hostDirectory, 'cargo-home'))).toBe(false);
+    expect(existsSync(join(hostDirectory, 'target'))).toBe(false);
+  });
+
+  it('keeps the downloads', () => {
+    expect(DockerCache.prune({ hostDirectory, keepDownloads: true })).toEqual([join(hostDirectory, 'target')]);
+    expect(existsSync(join(hostDirectory, 'cargo-home', 'registry'))).toBe(true);
+  });
+});
@@ -0,0 +1,127 @@
+import { existsSync } from 'fs';
+import { join } from 'path';
+import { env } from 'process';
+import { App, Stack } from 'aws-cdk-lib';
+import { Template } from 'aws-cdk-lib/assertions';
+import { Architecture } from 'aws-cdk-lib/aws-lambda';
+import { RustFunction, RustRuntime, RustWorkspace, cargoLambdaVersion } from '../src/index';
+
+const forcedDockerBundling = !!env.FORCE_DOCKER_RUN || !cargoLambdaVersion();
+const testSource = join(__dirname, 'fixtures/cargo-workspace');
+
+describe('CargoLambda.RustWorkspace', () => {
+  describe('With several functions in the workspace', () => {
+    const app = new App();
+    const stack = new Stack(app);
+
+    const workspace = new RustWorkspace(stack, 'workspace', {
+      manifestPath: testSource,
+      binaries: ['binary1', 'binary2'],
+      bundling: {
+        forcedDockerBundling,
+      },
+    });
+
+    new RustFunction(stack, 'rust function 1', {
+      workspace,
+      binaryName: 'binary1',
+    });
+
+    new RustFunction(stack, 'rust function 2', {
+      workspace,
+      binaryName: 'binary2',
+    });
+
+    test('bundle functions', () => {
+      const template = Template.fromStack(stack);
+      template.resourceCountIs('AWS::Lambda::Function', 2);
+
+      app.synth();
+    });
+  });
+
+  it('deploys the functions with the runtime of the workspace', () => {
+    const stack = new Stack(new App({ context: { 'aws:cdk:bundling-stacks': [] } }));
+    const workspace = new RustWorkspace(stack, 'workspace', {
+      manifestPath: testSource,
+      binaries: ['binary1'],
+      runtime: RustRuntime.PROVIDED_AL2,
+    });
+    new RustFunction(stack, 'rust function', { workspace, binaryName: 'binary1' });
+
+    Template.fromStack(stack).hasResourceProperties('AWS::Lambda::Function', { Runtime: 'provided.al2' });
+  });
+
+  it('does not write into the sources when bundling is skipped', () => {
+    const app = new App({ context: { 'aws:cdk:bundling-stacks': [] } });
+    const stack = new Stack(app);
+    const workspace = new RustWorkspace(stack, 'workspace', {
+      manifestPath: testSource,
+      binaries: ['binary1'],
+    });
+
+    // the staged path is the workspace, which already has a `binary1` directory
+    const code = workspace.binaryCode('binary1');
+    expect(code.path.startsWith(testSource)).toBe(false);
+    expect(existsSync(code.path)).toBe(true);
+  });
+
+  describe('Validations', () => {
+    const app = new App();
+    const stack = new Stack(app);
+
+    const workspace = new RustWorkspace(stack, 'workspace', {
+      manifestPath: testSource,
+      binaries: ['binary1'],
+      bundling: {
+        architecture: Architecture.ARM_64,
+      },
+    });
+
+    it('fails without binaries', () => {
+      expect(() => new RustWorkspace(stack, 'empty workspace', {
+        manifestPath: testSource,
+        binaries: [],
+      })).toThrow('the workspace doesn\'t include any binaries');
+    });
+
+    it('fails without a binary name', () => {
+      expect(() => new RustFunction(stack, 'no binary', { workspace })).toThrow(
+        'the function is built by a workspace, use the option `binaryName` to specify the binary to build',
+      );
+    });
+
+    it('fails with binaries that have the same name', () => {
+      expect(() => new RustWorkspace(stack, 'duplicate binaries', {
+        manifestPath: join(__dirname, 'fixtures/duplicate-binaries'),
+        binaries: ['lambda'],
+      })).toThrow('the binary `lambda` is declared by the packages a, b');
+    });
+
+    it('fails with a binary that is not in the workspace', () => {
+      expect(() => workspace.binaryCode('binary2')).toThrow(
+        'the binary `binary2` is not part of the workspace binaries: binary1',
+      );
+    });
+
+    it('fails with a runtime mismatch', () => {
+      expect(() => new RustFunction(stack, 'al2 function', {
+        workspace,
+        binaryName: 'binary1',
+        runtime: RustRuntime.PROVIDED_AL2,
+      })).toThrow(
+        'Runtime mismatch: the binaries of the workspace are built for provided.al2023, but the runtime of the function is provided.al2',
+      );
+    });
+
+    it('fails with an architecture mismatch', () => {
+      expect(() => new RustFunction(stack, 'x86 function', {
+        workspace,
+        binaryName: 'binary1',
+        architecture: Architecture.X86_64,
+      })).toThrow(
+        'Architecture mismatch: the architecture of the workspace (arm64) didn\'t match the architecture of the underlying lambda (x86_64).',
+      );
+    });
+  });
+});